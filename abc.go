@@ -0,0 +1,321 @@
+package main
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/hanwen/go-enc2ly/abc"
+	"github.com/hanwen/go-enc2ly/encore"
+	"github.com/hanwen/go-enc2ly/lily"
+)
+
+// abcVoice builds the ABC music of a voice. ABC needs a bar line
+// between all measures, so the voice fills gaps with spacers and
+// adds the bar lines itself as it moves through the measures.
+type abcVoice struct {
+	voice    *abc.Voice
+	measures []*encore.Measure
+
+	// cur is the index of the current measure, and next the
+	// tick after the last note or rest.
+	cur  int
+	next int
+
+	// initKey and initClef are the key and clef at the start.
+	initKey  *abc.Key
+	initClef string
+
+	// fifths is the current key, and accid has the accidentals
+	// written in the current measure.
+	fifths int
+	accid  accidentals
+
+	beam      *encore.Beam
+	tuplet    *abc.Tuplet
+	lastChord *abc.Chord
+
+	// breaks has the measures that start an Encore line.
+	line   *encore.Line
+	breaks map[int]bool
+}
+
+// newABCVoice returns a voice in the key with the given fifths,
+// unless it sets its own.
+func newABCVoice(id string, measures []*encore.Measure, fifths int) *abcVoice {
+	v := &abcVoice{
+		voice:    &abc.Voice{ID: id},
+		measures: measures,
+		breaks:   map[int]bool{},
+		fifths:   fifths,
+		accid:    accidentals{},
+	}
+	if len(measures) > 0 {
+		m := measures[0]
+		if m.BarTypeStart == 2 || m.RepeatAlternative > 0 {
+			v.append(&abc.Bar{Type: abcBar(convertBarType(0, m.BarTypeStart)), Volta: int(m.RepeatAlternative)})
+		}
+	}
+	return v
+}
+
+func (v *abcVoice) append(e abc.Elem) {
+	if v.tuplet != nil {
+		v.tuplet.Elems = append(v.tuplet.Elems, e)
+	} else {
+		v.voice.Elems = append(v.voice.Elems, e)
+	}
+}
+
+// advance moves to tick, adding spacers and bar lines.
+func (v *abcVoice) advance(tick int) {
+	for v.cur < len(v.measures) {
+		m := v.measures[v.cur]
+		end := m.AbsTick + int(m.DurTicks)
+		if v.next < tick && v.next < end {
+			to := end
+			if tick < to {
+				to = tick
+			}
+			v.append(&abc.Rest{Length: ticksLength(to - v.next), Invisible: true})
+			v.next = to
+		}
+		if tick < end || v.cur+1 == len(v.measures) {
+			return
+		}
+		v.cur++
+		v.bar(m, v.measures[v.cur])
+	}
+}
+
+// bar adds the bar line between prev and m. m is nil at the end.
+func (v *abcVoice) bar(prev, m *encore.Measure) {
+	var start byte
+	if m != nil {
+		start = m.BarTypeStart
+	}
+	b := &abc.Bar{Type: abcBar(convertBarType(prev.BarTypeEnd, start))}
+	v.accid = accidentals{}
+	if m == nil {
+		if b.Type == "|" {
+			b.Type = "|]"
+		}
+		v.append(b)
+		return
+	}
+	if m.RepeatAlternative != prev.RepeatAlternative {
+		b.Volta = int(m.RepeatAlternative)
+	}
+	v.append(b)
+	if prev.TimeSignature() != m.TimeSignature() {
+		v.append(&abc.Meter{Num: int(m.TimeSigNum), Den: int(m.TimeSigDen)})
+	}
+	if v.breaks[m.Id] {
+		v.append(&abc.Newline{})
+	}
+}
+
+// finish fills the voice up to the last measure and closes it.
+func (v *abcVoice) finish() {
+	if len(v.measures) == 0 {
+		return
+	}
+	last := v.measures[len(v.measures)-1]
+	v.tuplet = nil
+	v.advance(last.AbsTick + int(last.DurTicks))
+	v.bar(last, nil)
+}
+
+func (v *abcVoice) startMeasure(e *encore.MeasElem, prev *encore.Measure) {
+	if e.LineStaffData == nil {
+		return
+	}
+	if l := e.LineStaffData.Line; l != v.line {
+		if v.line != nil {
+			v.breaks[e.Measure.Id] = true
+		}
+		v.line = l
+	}
+}
+
+func (v *abcVoice) barCheck()                       {}
+func (v *abcVoice) timeSignature(m *encore.Measure) {}
+func (v *abcVoice) skip(ticks int)                  {}
+
+func (v *abcVoice) key(e *encore.MeasElem, key byte) {
+	k := &abc.Key{Fifths: keyFifths(key)}
+	v.fifths = k.Fifths
+	if v.initKey == nil {
+		v.initKey = k
+		return
+	}
+	v.advance(e.AbsTick())
+	v.append(k)
+}
+
+func (v *abcVoice) clef(e *encore.MeasElem, clef byte) {
+	if v.initClef == "" {
+		v.initClef = abcClef(clef)
+		return
+	}
+	v.advance(e.AbsTick())
+	v.append(&abc.Clef{Name: abcClef(clef)})
+}
+
+func (v *abcVoice) tupletStart(e *encore.MeasElem, b *encore.Beam) {
+	v.advance(e.AbsTick())
+	v.beam = b
+	v.tuplet = &abc.Tuplet{}
+	v.voice.Elems = append(v.voice.Elems, v.tuplet)
+}
+
+func (v *abcVoice) tupletEnd() {
+	v.tuplet = nil
+}
+
+func (v *abcVoice) setTuplet(w *encore.WithDuration) {
+	if v.tuplet != nil && v.tuplet.P == 0 {
+		v.tuplet.P, v.tuplet.Q = tupletRatio(v.beam, w)
+	}
+}
+
+func (v *abcVoice) tie(e *encore.MeasElem) {
+	if v.lastChord != nil {
+		v.lastChord.Tie = true
+	}
+}
+
+func (v *abcVoice) moveTo(e *encore.MeasElem) {
+	v.advance(e.AbsTick())
+	if end := e.AbsTick() + e.GetDurationTick(); end > v.next {
+		v.next = end
+	}
+}
+
+func (v *abcVoice) note(e *encore.MeasElem, n *encore.Note, clef byte, chord bool) {
+	lp, d := convertNote(n, basePitch(clef))
+	if chord && v.lastChord != nil {
+		v.lastChord.Pitch = append(v.lastChord.Pitch, v.pitch(lp))
+		return
+	}
+	v.moveTo(e)
+	v.setTuplet(&n.WithDuration)
+	v.lastChord = &abc.Chord{
+		Pitch:  []abc.Pitch{v.pitch(lp)},
+		Length: abcLength(d),
+	}
+	v.append(v.lastChord)
+}
+
+func (v *abcVoice) rest(e *encore.MeasElem, r *encore.Rest) {
+	v.moveTo(e)
+	v.setTuplet(&r.WithDuration)
+	v.append(&abc.Rest{Length: abcLength(convertRest(r))})
+	v.lastChord = nil
+}
+
+// pitch returns p, with an accidental if the key and the earlier
+// accidentals in the measure need one.
+func (v *abcVoice) pitch(p lily.Pitch) abc.Pitch {
+	r := abcPitch(p)
+	r.Accidental = v.accid.add(v.fifths, r.Step, r.Octave, r.Alteration)
+	return r
+}
+
+func abcPitch(p lily.Pitch) abc.Pitch {
+	p.Normalize()
+	return abc.Pitch{
+		Step:       p.Notename,
+		Octave:     p.Octave + 4,
+		Alteration: p.Alteration,
+	}
+}
+
+// abcLength returns the length of d as a fraction of a whole note.
+func abcLength(d lily.Duration) *big.Rat {
+	l := big.NewRat(1, 1)
+	if d.DurationLog >= 0 {
+		l.SetFrac64(1, int64(1)<<uint(d.DurationLog))
+	} else {
+		l.SetInt64(int64(1) << uint(-d.DurationLog))
+	}
+	dot := new(big.Rat).Set(l)
+	for i := 0; i < d.Dots; i++ {
+		dot.Quo(dot, big.NewRat(2, 1))
+		l.Add(l, dot)
+	}
+	return l
+}
+
+func ticksLength(ticks int) *big.Rat {
+	return big.NewRat(int64(ticks), 4*240)
+}
+
+func abcClef(clef byte) string {
+	switch clef {
+	case 1:
+		return "bass"
+	case 2:
+		return "alto"
+	case 3:
+		return "tenor"
+	case 4:
+		return "treble+8"
+	case 5:
+		return "treble-8"
+	}
+	return "treble"
+}
+
+// abcBar returns the ABC bar line for a bar type from
+// convertBarType.
+func abcBar(barType string) string {
+	switch barType {
+	case ":|:":
+		return "::"
+	case "|.":
+		return "|]"
+	case ":":
+		return ".|"
+	case "|:", ":|", "||":
+		return barType
+	}
+	return "|"
+}
+
+// ConvertABC returns an ABC tune for data, with a voice for each
+// staff and voice, named as in Convert.
+func ConvertABC(data *encore.Data, diag *Diagnostics) *abc.Tune {
+	t := &abc.Tune{Number: 1}
+	t.Notes = append(t.Notes, data.Text...)
+	if len(data.Measures) > 0 {
+		m := data.Measures[0]
+		t.Meter = m.TimeSignature()
+		t.Tempo = int(m.Bpm)
+	}
+
+	staves, keys := voices(data)
+	named := map[int]bool{}
+	for i, k := range keys {
+		v := newABCVoice(k.String(), data.Measures, t.Key.Fifths)
+		walkVoice(staves[k], v, diag)
+		v.finish()
+
+		if i == 0 && v.initKey != nil {
+			t.Key = *v.initKey
+		} else if v.initKey != nil && v.initKey.Fifths != t.Key.Fifths {
+			v.voice.Elems = append([]abc.Elem{v.initKey}, v.voice.Elems...)
+		}
+		v.voice.Clef = v.initClef
+		if !named[k.staff] && k.staff < len(data.Staff) {
+			v.voice.Name = data.Staff[k.staff].DisplayName()
+			named[k.staff] = true
+		}
+		t.Voices = append(t.Voices, v.voice)
+	}
+	return t
+}
+
+// WriteABC writes data in ABC notation.
+func WriteABC(w io.Writer, data *encore.Data, diag *Diagnostics) error {
+	return abc.Write(w, ConvertABC(data, diag))
+}
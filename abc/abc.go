@@ -0,0 +1,281 @@
+// Package abc writes tunes in ABC notation (version 2.1).
+//
+// Pitches are absolute. The caller decides which of them need an
+// accidental, given the key signature and the accidentals earlier in
+// the bar.
+package abc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+type Elem interface{}
+
+// Pitch is a note name (0 = C, 6 = B), an octave (4 is the octave
+// of middle C) and an alteration in semitones. Accidental is set if
+// the alteration is written.
+type Pitch struct {
+	Step       int
+	Octave     int
+	Alteration int
+	Accidental bool
+}
+
+// Name returns the note without accidental, eg. "C" for middle C,
+// "c'" two octaves up and "C," an octave down.
+func (p *Pitch) Name() string {
+	n := string("CDEFGAB"[p.Step])
+	switch {
+	case p.Octave >= 5:
+		return strings.ToLower(n) + strings.Repeat("'", p.Octave-5)
+	default:
+		return n + strings.Repeat(",", 4-p.Octave)
+	}
+}
+
+// Key is a major key, by its number of sharps (positive) or flats
+// (negative).
+type Key struct {
+	Fifths int
+}
+
+func (k *Key) String() string {
+	names := []string{"Cb", "Gb", "Db", "Ab", "Eb", "Bb", "F",
+		"C", "G", "D", "A", "E", "B", "F#", "C#"}
+	if k.Fifths < -7 || k.Fifths > 7 {
+		return "C"
+	}
+	return names[k.Fifths+7]
+}
+
+// Clef is an inline clef change, eg. "bass", for the voice it is in.
+type Clef struct {
+	Name string
+}
+
+// Meter is an inline time signature change.
+type Meter struct {
+	Num, Den int
+}
+
+// Chord is a note, or several notes with the same length. Length is
+// a fraction of a whole note. Tie ties all notes to the next ones.
+type Chord struct {
+	Pitch  []Pitch
+	Length *big.Rat
+	Tie    bool
+}
+
+// Rest is a rest; an invisible rest is a spacer.
+type Rest struct {
+	Length    *big.Rat
+	Invisible bool
+}
+
+// Bar is a bar line, eg. "|", "||", "|:", ":|", "::" or "|]", with
+// an optional first or second ending starting after it.
+type Bar struct {
+	Type  string
+	Volta int
+}
+
+// Tuplet plays the notes of Elems P in the time of Q.
+type Tuplet struct {
+	P, Q  int
+	Elems []Elem
+}
+
+// Newline ends a line of music.
+type Newline struct{}
+
+type Voice struct {
+	ID    string
+	Name  string
+	Clef  string
+	Elems []Elem
+}
+
+type Tune struct {
+	Number int
+	Titles []string
+	Notes  []string
+	Meter  string
+
+	// UnitLength is the note length of L:, default 1/8.
+	UnitLength *big.Rat
+
+	// Tempo in quarters per minute, or 0.
+	Tempo int
+	Key   Key
+
+	Voices []*Voice
+}
+
+func (t *Tune) unit() *big.Rat {
+	if t.UnitLength == nil {
+		return big.NewRat(1, 8)
+	}
+	return t.UnitLength
+}
+
+// Write writes t.
+func Write(w io.Writer, t *Tune) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "X:%d\n", t.Number)
+	// The title must follow X:, so a tune without one gets an empty
+	// title.
+	titles := t.Titles
+	if len(titles) == 0 {
+		titles = []string{""}
+	}
+	for _, s := range titles {
+		fmt.Fprintf(bw, "T:%s\n", s)
+	}
+	for _, s := range t.Notes {
+		fmt.Fprintf(bw, "N:%s\n", s)
+	}
+	if t.Meter != "" {
+		fmt.Fprintf(bw, "M:%s\n", t.Meter)
+	}
+	fmt.Fprintf(bw, "L:%s\n", t.unit().RatString())
+	if t.Tempo > 0 {
+		fmt.Fprintf(bw, "Q:1/4=%d\n", t.Tempo)
+	}
+	for _, v := range t.Voices {
+		fmt.Fprintf(bw, "V:%s", v.ID)
+		if v.Clef != "" {
+			fmt.Fprintf(bw, " clef=%s", v.Clef)
+		}
+		if v.Name != "" {
+			fmt.Fprintf(bw, ` name="%s"`, strings.Replace(v.Name, `"`, "'", -1))
+		}
+		bw.WriteString("\n")
+	}
+	fmt.Fprintf(bw, "K:%s\n", &t.Key)
+
+	for _, v := range t.Voices {
+		fmt.Fprintf(bw, "V:%s\n", v.ID)
+		vw := &voiceWriter{
+			w:    bw,
+			id:   v.ID,
+			unit: t.unit(),
+		}
+		vw.elems(v.Elems)
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// voiceWriter writes the music of a voice.
+type voiceWriter struct {
+	w    *bufio.Writer
+	id   string
+	unit *big.Rat
+
+	// sep is written before the next token.
+	sep string
+}
+
+func (vw *voiceWriter) elems(elems []Elem) {
+	for _, e := range elems {
+		vw.elem(e)
+	}
+}
+
+func (vw *voiceWriter) elem(e Elem) {
+	switch t := e.(type) {
+	case *Chord:
+		var s string
+		for _, p := range t.Pitch {
+			s += p.accidental() + p.Name()
+		}
+		if len(t.Pitch) > 1 {
+			s = "[" + s + "]"
+		}
+		s += vw.length(t.Length)
+		if t.Tie {
+			s += "-"
+		}
+		vw.token(s)
+	case *Rest:
+		s := "z"
+		if t.Invisible {
+			s = "x"
+		}
+		vw.token(s + vw.length(t.Length))
+	case *Bar:
+		s := t.Type
+		if t.Volta > 0 {
+			if !strings.HasSuffix(s, "|") {
+				s += " ["
+			}
+			s += fmt.Sprint(t.Volta)
+		}
+		vw.token(s)
+	case *Tuplet:
+		n := 0
+		for _, e := range t.Elems {
+			switch e.(type) {
+			case *Chord, *Rest:
+				n++
+			}
+		}
+		if t.Q == 2 && t.P == 3 || t.Q == 3 && (t.P == 2 || t.P == 4) {
+			vw.token(fmt.Sprintf("(%d", t.P))
+		} else {
+			vw.token(fmt.Sprintf("(%d:%d:%d", t.P, t.Q, n))
+		}
+		vw.sep = ""
+		vw.elems(t.Elems)
+	case *Key:
+		vw.token(fmt.Sprintf("[K:%s]", t))
+	case *Clef:
+		vw.token(fmt.Sprintf("[V:%s clef=%s]", vw.id, t.Name))
+	case *Meter:
+		vw.token(fmt.Sprintf("[M:%d/%d]", t.Num, t.Den))
+	case *Newline:
+		vw.w.WriteString("\n")
+		vw.sep = ""
+	}
+}
+
+// token writes s, separated from the previous token on the line.
+func (vw *voiceWriter) token(s string) {
+	vw.w.WriteString(vw.sep + s)
+	vw.sep = " "
+}
+
+// accidental returns the accidental of p, if it is written.
+func (p *Pitch) accidental() string {
+	if !p.Accidental {
+		return ""
+	}
+	switch {
+	case p.Alteration > 0:
+		return strings.Repeat("^", p.Alteration)
+	case p.Alteration < 0:
+		return strings.Repeat("_", -p.Alteration)
+	}
+	return "="
+}
+
+// length returns l as a multiple of the unit length, eg. "2" or
+// "3/2".
+func (vw *voiceWriter) length(l *big.Rat) string {
+	r := new(big.Rat).Quo(l, vw.unit)
+	switch {
+	case r.Cmp(big.NewRat(1, 1)) == 0:
+		return ""
+	case r.IsInt():
+		return r.Num().String()
+	case r.Num().Int64() == 1 && r.Denom().Int64() == 2:
+		return "/"
+	case r.Num().Int64() == 1:
+		return "/" + r.Denom().String()
+	}
+	return r.RatString()
+}
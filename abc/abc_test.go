@@ -0,0 +1,67 @@
+package abc
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestPitchName(t *testing.T) {
+	for _, c := range []struct {
+		p    Pitch
+		want string
+	}{
+		{Pitch{Step: 0, Octave: 4}, "C"},
+		{Pitch{Step: 0, Octave: 5}, "c"},
+		{Pitch{Step: 6, Octave: 6}, "b'"},
+		{Pitch{Step: 4, Octave: 2}, "G,,"},
+	} {
+		if got := c.p.Name(); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.p, got, c.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	eighth := big.NewRat(1, 8)
+	f := func(alt int, acc bool) *Chord {
+		return &Chord{Pitch: []Pitch{{Step: 3, Octave: 5, Alteration: alt, Accidental: acc}}, Length: eighth}
+	}
+	tune := &Tune{
+		Number: 1,
+		Titles: []string{"Air"},
+		Meter:  "3/4",
+		Key:    Key{Fifths: 1},
+		Voices: []*Voice{{
+			ID:   "v1",
+			Name: `The "Flute"`,
+			Elems: []Elem{
+				f(1, false), f(0, true), f(0, false), f(1, true),
+				&Rest{Length: big.NewRat(1, 4)},
+				&Bar{Type: "|"},
+				&Clef{Name: "bass"},
+				f(1, false),
+				&Tuplet{P: 3, Q: 2, Elems: []Elem{f(1, false), f(1, false), f(1, false)}},
+				&Chord{Pitch: []Pitch{{Step: 0, Octave: 5}, {Step: 2, Octave: 5}}, Length: big.NewRat(3, 16), Tie: true},
+				&Rest{Length: big.NewRat(1, 16), Invisible: true},
+				&Bar{Type: ":|", Volta: 2},
+			},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, tune); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := `X:1
+T:Air
+M:3/4
+L:1/8
+V:v1 name="The 'Flute'"
+K:G
+V:v1
+f =f f ^f z2 | [V:v1 clef=bass] f (3f f f [ce]3/2- x/ :|2
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
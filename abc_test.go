@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestConvertABC(t *testing.T) {
+	header := []string{
+		"X:1",
+		"T:",
+		"M:4/4",
+		"L:1/8",
+		"Q:1/4=120",
+	}
+	for _, tc := range []struct {
+		name string
+		data *encore.Data
+		want []string
+	}{
+		{
+			name: "music",
+			data: testMusic(t),
+			want: []string{
+				`V:staffAvoiceA clef=treble name="Staff 1"`,
+				`V:staffBvoiceB clef=treble name="Staff 2"`,
+				"K:C",
+				"V:staffAvoiceA",
+				"[CE]2 (3D E F z2 G2- | G2 z4 z2 |]",
+				"V:staffBvoiceB",
+				"C4 [V:staffBvoiceB clef=bass] C,4 | G,,8 |]",
+			},
+		},
+		{
+			name: "voices",
+			data: testTwoVoices(t),
+			want: []string{
+				`V:staffAvoiceA clef=treble name="Staff 1"`,
+				"V:staffAvoiceB clef=treble",
+				`V:staffBvoiceA clef=treble name="Staff 2"`,
+				"K:C",
+				"V:staffAvoiceA",
+				"G4 (3D E F G2- :: G8 :|",
+				"V:staffAvoiceB",
+				"C8 :: C8 :|",
+				"V:staffBvoiceA",
+				"x8 :: C8 :|",
+			},
+		},
+	} {
+		var diag Diagnostics
+		var buf bytes.Buffer
+		if err := WriteABC(&buf, tc.data, &diag); err != nil {
+			t.Fatalf("%s: WriteABC: %v", tc.name, err)
+		}
+		for _, e := range diag.Entries {
+			t.Errorf("%s: diagnostic %v", tc.name, e)
+		}
+		want := strings.Join(append(header, tc.want...), "\n") + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, got, want)
+		}
+	}
+}
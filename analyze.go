@@ -11,10 +11,10 @@ import (
 
 func analyze(d *encore.Data) {
 	//	analyzeTags(content)
-	//	Convert(d)
+	//	analyzeExtra(d)
+	//	Convert(os.Stdout, d)
 	//	analyzeStaff(d)
 	//	messM(d)
-	//mess(d)
 	//	analyzeKeyCh(d)
 	//	analyzeAll(d)
 	//	analyzeStaff(d)
@@ -63,6 +63,12 @@ func analyzeTags(content []byte) {
 	}
 }
 
+func analyzeExtra(d *encore.Data) {
+	for i, s := range d.Extra {
+		fmt.Printf("section %d: %q at %d, %d bytes\n", i, s.Tag, s.Offset, s.Size)
+	}
+}
+
 func analyzeLine(d *encore.Data) {
 	for i, l := range d.Lines {
 		fmt.Printf("%v\n", l)
@@ -133,39 +139,14 @@ func analyzeBeam(d *encore.Data) {
 }
 
 func messM(d *encore.Data) {
-	raw := make([]byte, len(d.Raw))
-	copy(raw, d.Raw)
-
-	encore.ReadData(raw)
-
-	err := ioutil.WriteFile("mess.enc", raw, 0644)
-	if err != nil {
-		log.Fatalf("WriteFile:", err)
+	var buf bytes.Buffer
+	if err := encore.NewEncoder(&buf).Encode(d); err != nil {
+		log.Fatalf("Encode: %v", err)
 	}
 
-}
-
-func mess(d *encore.Data) {
-	fmt.Printf("mess\n")
-	for i := 0; i < 13; i++ {
-		raw := make([]byte, len(d.Raw))
-		copy(raw, d.Raw)
-
-		for _, m := range d.Measures[5:6] {
-			for _, e := range m.Elems {
-				if e.GetStaff() == 6 {
-					raw[e.GetOffset()+5+i] += 3
-				}
-			}
-		}
-
-		encore.ReadData(raw)
-		fmt.Printf("messed\n")
-
-		err := ioutil.WriteFile(fmt.Sprintf("mess%d.enc", i), raw, 0644)
-		if err != nil {
-			log.Fatalf("WriteFile:", err)
-		}
+	err := ioutil.WriteFile("mess.enc", buf.Bytes(), 0644)
+	if err != nil {
+		log.Fatalf("WriteFile: %v", err)
 	}
 }
 
@@ -2,9 +2,10 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"math/big"
 	"sort"
+	"strings"
 
 	"github.com/hanwen/go-enc2ly/lily"
 	"github.com/hanwen/go-enc2ly/encore"
@@ -15,8 +16,6 @@ import (
 // TODO - slur elements
 // slurs are tricky: they are not explicitly linked to their encompassing notes.
 
-// TODO - clef changes,
-
 type elemSequence []*encore.MeasElem
 
 func (e elemSequence) Len() int {
@@ -68,7 +67,7 @@ func (e idKeys) Len() int {
 
 func (e idKeys) Less(i, j int) bool {
 	if e[i].staff == e[j].staff {
-		return e[i].voice == e[j].voice
+		return e[i].voice < e[j].voice
 	}
 	return e[i].staff < e[j].staff
 }
@@ -81,28 +80,18 @@ func (i *idKey) String() string {
 	return fmt.Sprintf("staff%svoice%s", Int2Letter(i.staff), Int2Letter(i.voice))
 }
 
-func Convert(data *encore.Data) {
-	staves := map[idKey][]*encore.MeasElem{}
-	for _, m := range data.Measures {
-		for _, e := range m.Elems {
-			key := idKey{
-				staff: int(e.StaffIdx),
-				voice: e.Voice(),
-			}
-			staves[key] = append(staves[key], e)
-		}
-	}
-	sortedKeys := idKeys{}
-	for k := range staves {
-		sortedKeys = append(sortedKeys, k)
+// Convert writes data as LilyPond. Problems with the input are added
+// to diag.
+func Convert(w io.Writer, data *encore.Data, diag *Diagnostics) {
+	for _, t := range data.Text {
+		fmt.Fprint(w, &lily.Markup{Text: t})
 	}
-	sort.Sort(sortedKeys)
+
+	staves, sortedKeys := voices(data)
 	staffVoiceMap := make(map[int][]idKey, len(data.Staff))
 	for _, k := range sortedKeys {
-		elems := staves[k]
-		sort.Sort(elemSequence(elems))
-		seq := convertStaff(elems)
-		fmt.Printf("%v = %v\n", k.String(), seq)
+		seq := convertStaff(staves[k], diag)
+		fmt.Fprintf(w, "%v = %v\n", k.String(), seq)
 		staffVoiceMap[k.staff] = append(staffVoiceMap[k.staff], k)
 	}
 
@@ -111,16 +100,54 @@ func Convert(data *encore.Data) {
 		sortedStaves = append(sortedStaves, k)
 	}
 	sort.Ints(sortedStaves)
-	fmt.Printf("<<\n")
+	fmt.Fprintf(w, "<<\n")
 	for _, k := range sortedStaves {
 		voices := staffVoiceMap[k]
-		fmt.Printf("  \\new Staff << \n")
+		fmt.Fprintf(w, "  \\new Staff << \n")
+		if k < len(data.Staff) {
+			for _, p := range convertStaffSettings(data.Staff[k]) {
+				fmt.Fprintf(w, "  %v\n", p)
+			}
+		}
 		for _, voice := range voices {
-			fmt.Printf("  \\new Voice \\%s\n", voice.String())
+			fmt.Fprintf(w, "  \\new Voice \\%s\n", voice.String())
 		}
-		fmt.Printf(">>\n")
+		fmt.Fprintf(w, ">>\n")
+	}
+	fmt.Fprintf(w, ">>\n")
+}
+
+// shortName abbreviates an instrument name, eg. "Flauto" to "Fla.".
+func shortName(name string) string {
+	r := []rune(strings.Fields(name)[0])
+	if len(r) <= 4 {
+		return string(r)
+	}
+	return string(r[:3]) + "."
+}
+
+// convertStaffSettings returns the names and MIDI settings for a
+// staff. The MIDI settings are those of the first voice.
+func convertStaffSettings(s *encore.Staff) []*lily.PropertySet {
+	var result []*lily.PropertySet
+	if name := s.DisplayName(); name != "" {
+		result = append(result,
+			&lily.PropertySet{Context: "Staff", Name: "instrumentName", Value: lily.Quote(name)},
+			&lily.PropertySet{Context: "Staff", Name: "shortInstrumentName", Value: lily.Quote(shortName(name))})
+	}
+
+	result = append(result, &lily.PropertySet{
+		Context: "Staff",
+		Name:    "midiInstrument",
+		Value:   lily.Quote(lily.MidiInstruments[s.Program(0)]),
+	})
+	if vol := s.Volume(0); vol > 0 {
+		v := fmt.Sprintf("%.2f", float64(vol)/127)
+		result = append(result,
+			&lily.PropertySet{Context: "Staff", Name: "midiMinimumVolume", Value: v},
+			&lily.PropertySet{Context: "Staff", Name: "midiMaximumVolume", Value: v})
 	}
-	fmt.Printf(">>\n")
+	return result
 }
 
 func convertClef(key byte) *lily.Clef {
@@ -180,7 +207,7 @@ func convertBarType(end byte, start byte) string {
 }
 
 func convertRest(n *encore.Rest) (dur lily.Duration) {
-	dur.DurationLog = int(n.FaceValue) - 1
+	dur.DurationLog = n.DurationLog()
 	if n.DotControl == 25 || n.DotControl == 29 {
 		dur.Dots = 1
 	}
@@ -254,124 +281,113 @@ func setTuplet(t *lily.Tuplet, w *encore.WithDuration) {
 	}
 }
 
-func convertStaff(elems []*encore.MeasElem) lily.Elem {
-	baseSeq := &lily.Seq{}
-	seq := baseSeq
-
-	lastTick := -1
-	var lastNote *lily.Chord
-	var articulations []string
-	var nextTick int
-	var endTupletTick int
-	var currentTuplet *lily.Tuplet
-	var currentVolta int
-	for i, e := range elems {
-		if e.AbsTick() != lastTick && lastNote != nil {
-			lastNote.PostEvents = append(lastNote.PostEvents, articulations...)
-			articulations = nil
-		}
-
-		if currentTuplet != nil && e.AbsTick() > endTupletTick {
-			seq = baseSeq
-			currentTuplet = nil
-			endTupletTick = 0
-		}
+// lilyVoice builds the LilyPond expression for a voice.
+type lilyVoice struct {
+	diag    *Diagnostics
+	baseSeq *lily.Seq
+	seq     *lily.Seq
 
-		if e.GetTick() == 0 && e.AbsTick() > lastTick && e.GetDurationTick() > 0 {
-			seq.Append(&lily.BarCheck{})
-		}
+	lastNote      *lily.Chord
+	currentTuplet *lily.Tuplet
+	currentVolta  int
+}
 
-		if i == 0 || e.Measure != elems[i-1].Measure && e.GetTick() == 0 {
-			var last byte
-			if i > 0 {
-				last = elems[i-1].Measure.BarTypeEnd
-			}
-			barType := convertBarType(last, e.Measure.BarTypeStart)
-			if barType != "|" {
-				seq.Append(&lily.Bar{Name: barType})
-			}
+func (v *lilyVoice) startMeasure(e *encore.MeasElem, prev *encore.Measure) {
+	var last byte
+	if prev != nil {
+		last = prev.BarTypeEnd
+	}
+	barType := convertBarType(last, e.Measure.BarTypeStart)
+	if barType != "|" {
+		v.seq.Append(&lily.Bar{Name: barType})
+	}
 
-			if volta := int(e.Measure.RepeatAlternative); volta != currentVolta {
-				val := "'((volta  #f))"
-				if volta > 0 {
-					val = fmt.Sprintf("'((volta \"%d\"))", volta)
-				}
-				r := &lily.PropertySet{
-					Context: "Score",
-					Name:    "repeatCommands",
-					Value:   val,
-				}
-				seq.Append(r)
-				currentVolta = volta
-			}
+	if volta := int(e.Measure.RepeatAlternative); volta != v.currentVolta {
+		val := "'((volta  #f))"
+		if volta > 0 {
+			val = fmt.Sprintf("'((volta \"%d\"))", volta)
 		}
-		if i == 0 || (e.GetTick() == 0 && elems[i-1].Measure.TimeSignature() != e.Measure.TimeSignature()) {
-			seq.Append(&lily.TimeSignature{
-				Num: int(e.Measure.TimeSigNum),
-				Den: int(e.Measure.TimeSigDen),
-			})
-		}
-		if i == 0 {
-			seq.Append(convertKey(e.LineStaffData.Key))
-			seq.Append(convertClef(e.LineStaffData.Clef))
+		r := &lily.PropertySet{
+			Context: "Score",
+			Name:    "repeatCommands",
+			Value:   val,
 		}
+		v.seq.Append(r)
+		v.currentVolta = volta
+	}
+}
 
-		if nextTick < e.AbsTick() {
-			seq.Append(skipTicks(e.AbsTick() - nextTick))
-			nextTick = e.AbsTick()
-		}
+func (v *lilyVoice) barCheck() {
+	v.seq.Append(&lily.BarCheck{})
+}
 
-		end := e.AbsTick() + e.GetDurationTick()
-		switch t := e.TypeSpecific.(type) {
-		case *encore.Beam:
-			if t.TupletNumber != 0 {
-				if currentTuplet != nil {
-					log.Panic("already have tuplet")
-				}
-
-				endTupletTick = e.Measure.AbsTick + int(t.EndNoteTick)
-				seq = new(lily.Seq)
-				currentTuplet = &lily.Tuplet{Elem: seq}
-				baseSeq.Append(currentTuplet)
-			}
-		case *encore.Tie:
-			if lastNote == nil {
-				log.Println("no last for tie ", lastTick)
-			} else {
-				articulations = append(articulations, "~")
-			}
-		case *encore.Note:
-			setTuplet(currentTuplet, &t.WithDuration)
-			p, d := convertNote(t, basePitch(e.LineStaffData.Clef))
-			if e.AbsTick() == lastTick {
-				if lastNote == nil {
-					log.Println("no last note at ", lastTick)
-					continue
-				}
-				lastNote.Pitch = append(lastNote.Pitch, p)
-			} else {
-				ch := lily.Chord{Duration: d}
-				ch.Pitch = append(ch.Pitch, p)
-				lastNote = &ch
-				seq.Append(lastNote)
-			}
-			lastTick = e.AbsTick()
-			if end > nextTick {
-				nextTick = end
-			}
-		case *encore.Rest:
-			setTuplet(currentTuplet, &t.WithDuration)
-			d := convertRest(t)
-			seq.Append(&lily.Rest{d})
-			if end > nextTick {
-				nextTick = end
-			}
-		case *encore.KeyChange:
-			seq.Append(convertKey(t.NewKey))
-		}
+func (v *lilyVoice) timeSignature(m *encore.Measure) {
+	v.seq.Append(&lily.TimeSignature{
+		Num: int(m.TimeSigNum),
+		Den: int(m.TimeSigDen),
+	})
+}
+
+func (v *lilyVoice) key(e *encore.MeasElem, key byte) {
+	v.seq.Append(convertKey(key))
+}
+
+func (v *lilyVoice) clef(e *encore.MeasElem, clef byte) {
+	v.seq.Append(convertClef(clef))
+}
+
+func (v *lilyVoice) skip(ticks int) {
+	v.seq.Append(skipTicks(ticks))
+}
+
+func (v *lilyVoice) tupletStart(e *encore.MeasElem, b *encore.Beam) {
+	v.seq = new(lily.Seq)
+	v.currentTuplet = &lily.Tuplet{Elem: v.seq}
+	v.baseSeq.Append(v.currentTuplet)
+}
+
+func (v *lilyVoice) tupletEnd() {
+	v.seq = v.baseSeq
+	v.currentTuplet = nil
+}
+
+func (v *lilyVoice) tie(e *encore.MeasElem) {
+	if v.lastNote == nil {
+		v.diag.Add(Warning, e, "tie without a note before it")
+		return
+	}
+	v.lastNote.PostEvents = append(v.lastNote.PostEvents, "~")
+}
+
+func (v *lilyVoice) note(e *encore.MeasElem, n *encore.Note, clef byte, chord bool) {
+	setTuplet(v.currentTuplet, &n.WithDuration)
+	p, d := convertNote(n, basePitch(clef))
+	if chord && v.lastNote != nil {
+		v.lastNote.Pitch = append(v.lastNote.Pitch, p)
+		return
 	}
-	if lastNote != nil {
-		lastNote.PostEvents = append(lastNote.PostEvents, articulations...)
+	ch := lily.Chord{Duration: d}
+	ch.Pitch = append(ch.Pitch, p)
+	v.lastNote = &ch
+	v.seq.Append(v.lastNote)
+}
+
+func (v *lilyVoice) rest(e *encore.MeasElem, r *encore.Rest) {
+	setTuplet(v.currentTuplet, &r.WithDuration)
+	v.seq.Append(&lily.Rest{Duration: convertRest(r)})
+}
+
+// staffClef returns the clef of the line staff holding e.
+func staffClef(e *encore.MeasElem) byte {
+	if e.LineStaffData == nil {
+		return 0
 	}
-	return baseSeq
+	return e.LineStaffData.Clef
+}
+
+func convertStaff(elems []*encore.MeasElem, diag *Diagnostics) lily.Elem {
+	v := &lilyVoice{diag: diag, baseSeq: &lily.Seq{}}
+	v.seq = v.baseSeq
+	walkVoice(elems, v, diag)
+	return v.baseSeq
 }
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestConvertStaffSettings(t *testing.T) {
+	s := &encore.Staff{}
+	copy(s.Name[:], "Violino I")
+	s.MidiProgram[0] = 40
+	s.MidiVolume[0] = 127
+	want := []string{
+		`\set Staff.instrumentName = #"Violino I"`,
+		`\set Staff.shortInstrumentName = #"Vio."`,
+		`\set Staff.midiInstrument = #"violin"`,
+		`\set Staff.midiMinimumVolume = #1.00`,
+		`\set Staff.midiMaximumVolume = #1.00`,
+	}
+	got := convertStaffSettings(s)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %q", got, want)
+	}
+	for i, p := range got {
+		if p.String() != want[i] {
+			t.Errorf("%d: got %s, want %s", i, p, want[i])
+		}
+	}
+
+}
+
+// testElem is an element for testScore.
+type testElem struct {
+	meas, tick, staff, voice int
+	spec                     encore.MeasElemSpecific
+}
+
+// elemTypes has the element type and size for each type specific
+// part used in tests.
+var elemTypes = map[string][2]int{
+	"Clef":      {encore.TYPE_CLEF, 12},
+	"KeyChange": {encore.TYPE_KEYCHANGE, 12},
+	"Tie":       {encore.TYPE_TIE, 16},
+	"Beam":      {encore.TYPE_BEAM, 30},
+	"Rest":      {encore.TYPE_REST, 20},
+	"Note":      {encore.TYPE_NOTE, 28},
+}
+
+// testScore returns a 4/4 score with the given number of staves and
+// measures on one line, holding elems. It is encoded and read back,
+// so it has offsets and raw bytes as if read from a file.
+func testScore(t testing.TB, staves, measures int, elems ...testElem) *encore.Data {
+	d := &encore.Data{}
+	d.Header.StaffPerSystem = byte(staves)
+	l := &encore.Line{LineData: encore.LineData{MeasureCount: byte(measures)}}
+	for i := 0; i < staves; i++ {
+		s := &encore.Staff{}
+		copy(s.Name[:], fmt.Sprintf("Staff %d", i+1))
+		d.Staff = append(d.Staff, s)
+		l.Staffs = append(l.Staffs, &encore.LineStaffData{StaffIdx: byte(i)})
+	}
+	d.Pages = []*encore.Page{{}}
+	d.Lines = []*encore.Line{l}
+	for i := 0; i < measures; i++ {
+		d.Measures = append(d.Measures, &encore.Measure{
+			Bpm:        120,
+			BeatTicks:  240,
+			DurTicks:   960,
+			TimeSigNum: 4,
+			TimeSigDen: 4,
+		})
+	}
+	for _, te := range elems {
+		typ := elemTypes[te.spec.GetTypeName()]
+		m := d.Measures[te.meas]
+		m.Elems = append(m.Elems, &encore.MeasElem{
+			Tick:         uint16(te.tick),
+			TypeVoice:    byte(typ[0]<<4 | te.voice),
+			Size:         byte(typ[1]),
+			StaffIdx:     byte(te.staff),
+			TypeSpecific: te.spec,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := encore.NewEncoder(&buf).Encode(d); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	d, err := encore.ReadData(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	return d
+}
+
+// testNote returns a note with face value fv (3 = quarter) at
+// position pos from the ledger line below the treble staff.
+func testNote(fv byte, pos int8, pitch byte) *encore.Note {
+	return &encore.Note{
+		WithDuration:  encore.WithDuration{FaceValue: fv},
+		Position:      pos,
+		SemitonePitch: pitch,
+	}
+}
+
+func testRest(fv byte) *encore.Rest {
+	return &encore.Rest{WithDuration: encore.WithDuration{FaceValue: fv}}
+}
+
+// testTriplet returns a triplet note, as in a triplet of eighths.
+func testTriplet(pos int8, pitch byte) *encore.Note {
+	n := testNote(4, pos, pitch)
+	n.Tuplet = 3<<4 | 2
+	return n
+}
+
+// testTupletBeam returns the beam starting a triplet whose last note
+// is at tick end.
+func testTupletBeam(end uint16) *encore.Beam {
+	return &encore.Beam{
+		TupletNumber: 3,
+		EndNoteTick:  end,
+		SubBeams:     make([]encore.SubBeam, 1),
+	}
+}
+
+// testMusic has two staves: a C major chord tied over the bar and a
+// triplet of eighths in the first, and a half note in voice 1 plus a
+// clef change to bass in the second.
+func testMusic(t testing.TB) *encore.Data {
+	return testScore(t, 2, 2,
+		testElem{0, 0, 0, 0, testNote(3, 0, 60)},
+		testElem{0, 0, 0, 0, testNote(3, 2, 64)},
+		testElem{0, 240, 0, 0, testTupletBeam(400)},
+		testElem{0, 240, 0, 0, testTriplet(1, 62)},
+		testElem{0, 320, 0, 0, testTriplet(2, 64)},
+		testElem{0, 400, 0, 0, testTriplet(3, 65)},
+		testElem{0, 480, 0, 0, testRest(3)},
+		testElem{0, 720, 0, 0, testNote(3, 4, 67)},
+		testElem{0, 720, 0, 0, &encore.Tie{}},
+		testElem{1, 0, 0, 0, testNote(3, 4, 67)},
+		testElem{1, 240, 0, 0, testRest(2)},
+		testElem{1, 720, 0, 0, testRest(3)},
+
+		testElem{0, 0, 1, 1, testNote(2, 0, 60)},
+		testElem{0, 480, 1, 1, &encore.Clef{ClefType: 1}},
+		testElem{0, 480, 1, 1, testNote(2, 5, 48)},
+		testElem{1, 0, 1, 1, testNote(1, 2, 43)},
+	)
+}
+
+// testTwoVoices has two staves. The first has a half note, a triplet
+// and a note tied over the repeat bar in voice 0, and whole notes in
+// voice 1. The second only has a note in the second measure.
+func testTwoVoices(t testing.TB) *encore.Data {
+	d := testScore(t, 2, 2,
+		testElem{0, 0, 0, 0, testNote(2, 4, 67)},
+		testElem{0, 480, 0, 0, testTupletBeam(640)},
+		testElem{0, 480, 0, 0, testTriplet(1, 62)},
+		testElem{0, 560, 0, 0, testTriplet(2, 64)},
+		testElem{0, 640, 0, 0, testTriplet(3, 65)},
+		testElem{0, 720, 0, 0, testNote(3, 4, 67)},
+		testElem{0, 720, 0, 0, &encore.Tie{}},
+		testElem{1, 0, 0, 0, testNote(1, 4, 67)},
+		testElem{0, 0, 0, 1, testNote(1, 0, 60)},
+		testElem{1, 0, 0, 1, testNote(1, 0, 60)},
+		testElem{1, 0, 1, 0, testNote(1, 0, 60)},
+	)
+	d.Measures[0].BarTypeEnd = 4
+	d.Measures[1].BarTypeStart = 2
+	d.Measures[1].BarTypeEnd = 4
+	return d
+}
+
+func TestVoices(t *testing.T) {
+	d := testScore(t, 2, 1,
+		testElem{0, 0, 1, 2, testNote(3, 0, 60)},
+		testElem{0, 0, 1, 0, testNote(3, 0, 60)},
+		testElem{0, 0, 0, 3, testNote(3, 0, 60)},
+		testElem{0, 0, 1, 1, testNote(3, 0, 60)},
+		testElem{0, 240, 1, 0, &encore.Clef{ClefType: 1}},
+		// The high bits of the staff are not part of the index.
+		testElem{0, 240, 64 + 1, 2, testNote(3, 0, 60)},
+	)
+	staves, keys := voices(d)
+	want := []idKey{{0, 3}, {1, 0}, {1, 1}, {1, 2}}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("got keys %v, want %v", keys, want)
+	}
+	for _, k := range keys[1:3] {
+		if es := staves[k]; len(es) != 2 || es[1].Type() != encore.TYPE_CLEF {
+			t.Errorf("%v: got %d elements, want a note and the clef", k, len(es))
+		}
+	}
+	if es := staves[keys[3]]; len(es) != 3 {
+		t.Errorf("%v: got %d elements, want two notes and the clef", keys[3], len(es))
+	}
+	if es := staves[keys[0]]; len(es) != 1 {
+		t.Errorf("%v: got %d elements, want 1", keys[0], len(es))
+	}
+}
+
+func TestKeyAlteration(t *testing.T) {
+	for _, tc := range []struct {
+		fifths, step, want int
+	}{
+		{0, 3, 0},
+		{1, 3, 1},
+		{1, 0, 0},
+		{2, 0, 1},
+		{-1, 6, -1},
+		{-2, 2, -1},
+		{-2, 5, 0},
+		{7, 6, 1},
+		{-7, 3, -1},
+		{9, 6, 1},
+	} {
+		if got := keyAlteration(tc.fifths, tc.step); got != tc.want {
+			t.Errorf("keyAlteration(%d, %d): got %d, want %d", tc.fifths, tc.step, got, tc.want)
+		}
+	}
+}
+
+func TestAccidentals(t *testing.T) {
+	a := accidentals{}
+	for i, tc := range []struct {
+		step, octave, alteration int
+		want                     bool
+	}{
+		{3, 4, 1, false},
+		{3, 4, 0, true},
+		{3, 4, 0, false},
+		{3, 5, 1, false},
+		{0, 4, 1, true},
+		{0, 4, 1, false},
+	} {
+		if got := a.add(1, tc.step, tc.octave, tc.alteration); got != tc.want {
+			t.Errorf("%d: got %v, want %v", i, got, tc.want)
+		}
+	}
+}
+
+// badFiles returns encoded scores, each with a value that used to
+// crash the converters.
+func badFiles(t testing.TB) map[string][]byte {
+	files := map[string][]byte{}
+	mutate := func(name string, d *encore.Data, off int, val byte) {
+		raw := append([]byte(nil), d.Raw...)
+		raw[off] = val
+		files[name] = raw
+	}
+
+	d := testMusic(t)
+	triplet := d.Measures[0].Elems[3]
+	mutate("tuplet", d, triplet.Offset+13, 0x02)
+	mutate("face value", d, triplet.Offset+5, 0xf)
+	l := d.Lines[0]
+	mutate("line key", d, l.Offset+len(l.Raw)+26+2, 20)
+
+	d = testScore(t, 1, 1,
+		testElem{0, 0, 0, 0, testNote(3, 0, 60)},
+		testElem{0, 240, 0, 0, &encore.KeyChange{NewKey: 9}},
+		testElem{0, 240, 0, 0, testNote(3, 0, 60)},
+	)
+	mutate("key change", d, d.Measures[0].Elems[1].Offset+5, 30)
+	return files
+}
+
+func TestConvertModes(t *testing.T) {
+	for name, raw := range badFiles(t) {
+		if _, err := encore.ReadDataOptions(raw, &encore.DecodeOptions{Mode: encore.Strict}); err == nil {
+			t.Errorf("%s: strict read succeeded", name)
+		}
+		for _, mode := range []encore.Mode{encore.Normal, encore.Lenient} {
+			d, err := encore.ReadDataOptions(raw, &encore.DecodeOptions{Mode: mode})
+			if err != nil {
+				t.Fatalf("%s: mode %d: %v", name, mode, err)
+			}
+			if len(d.Anomalies) != 1 {
+				t.Errorf("%s: mode %d: got anomalies %v, want 1", name, mode, d.Anomalies)
+			}
+			for f, write := range formats {
+				_, diag, err := convert(d, write, &ConvertOptions{Mode: mode})
+				if err != nil {
+					t.Errorf("%s: mode %d: %s: %v", name, mode, f, err)
+				}
+				if len(diag.Entries) == 0 {
+					t.Errorf("%s: mode %d: %s: no diagnostics", name, mode, f)
+				}
+			}
+		}
+	}
+
+	// Converting in strict mode fails on any diagnostic.
+	d, err := encore.ReadData(badFiles(t)["tuplet"])
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	if _, _, err := convert(d, WriteMusicXML, &ConvertOptions{Mode: encore.Strict}); err == nil {
+		t.Errorf("strict conversion succeeded")
+	}
+}
+
+func FuzzConvert(f *testing.F) {
+	f.Add(testMusic(f).Raw)
+	f.Add(testTwoVoices(f).Raw)
+	for _, raw := range badFiles(f) {
+		f.Add(raw)
+	}
+	f.Fuzz(func(t *testing.T, c []byte) {
+		d, err := encore.ReadDataOptions(c, &encore.DecodeOptions{Mode: encore.Lenient})
+		if err != nil {
+			return
+		}
+		for _, write := range formats {
+			convert(d, write, &ConvertOptions{Mode: encore.Lenient})
+		}
+		newScoreStats("fuzz.enc", d).write(ioutil.Discard)
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// coverageTop is the number of most frequent values shown for an
+// unmapped offset.
+const coverageTop = 6
+
+// structCoverage collects the bytes seen for one struct type.
+type structCoverage struct {
+	name  string
+	count int
+	size  int
+
+	// fields has the field covering each offset.
+	fields map[int]encore.Field
+
+	// hist has the value counts for the unmapped offsets.
+	hist map[int]map[byte]int
+}
+
+type coverageReport struct {
+	files   int
+	failed  int
+	structs map[string]*structCoverage
+}
+
+func newCoverageReport() *coverageReport {
+	return &coverageReport{structs: map[string]*structCoverage{}}
+}
+
+func (c *coverageReport) add(name string, raw []byte, fields []encore.Field) {
+	s := c.structs[name]
+	if s == nil {
+		s = &structCoverage{
+			name:   name,
+			fields: map[int]encore.Field{},
+			hist:   map[int]map[byte]int{},
+		}
+		c.structs[name] = s
+	}
+	s.count++
+	if len(raw) > s.size {
+		s.size = len(raw)
+	}
+	for _, f := range fields {
+		for i := f.Offset; i < f.Offset+f.Size; i++ {
+			s.fields[i] = f
+		}
+	}
+	for i, b := range raw {
+		if _, ok := s.fields[i]; ok {
+			continue
+		}
+		h := s.hist[i]
+		if h == nil {
+			h = map[byte]int{}
+			s.hist[i] = h
+		}
+		h[b]++
+	}
+}
+
+// elemFields returns the fields of e, including those of its type
+// specific part and its sub beams.
+func elemFields(e *encore.MeasElem) []encore.Field {
+	fields := encore.Fields(e)
+	if e.TypeSpecific == nil {
+		return fields
+	}
+	fields = append(fields, encore.Fields(e.TypeSpecific)...)
+	if b, ok := e.TypeSpecific.(*encore.Beam); ok {
+		for i := range b.SubBeams {
+			for _, f := range encore.Fields(&b.SubBeams[i]) {
+				f.Name = fmt.Sprintf("SubBeams[%d].%s", i, f.Name)
+				f.Offset += 14 + 16*i
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+func elemStructName(e *encore.MeasElem) string {
+	if e.TypeSpecific == nil {
+		return fmt.Sprintf("MeasElem (type %d)", e.Type())
+	}
+	name := e.GetTypeName()
+	if name == "Other" {
+		name = fmt.Sprintf("Other (type %d, size %d)", e.Type(), len(e.Raw))
+	}
+	return name
+}
+
+func (c *coverageReport) addData(d *encore.Data) {
+	c.add("Header", d.Header.Raw, encore.Fields(&d.Header))
+	for _, s := range d.Staff {
+		c.add("Staff", s.Raw, encore.Fields(s))
+	}
+	for _, p := range d.Pages {
+		c.add("Page", p.Raw, encore.Fields(p))
+	}
+	for _, l := range d.Lines {
+		c.add("Line", l.Raw, encore.Fields(l))
+		if len(l.VarData) >= 26 {
+			c.add("LineData", l.VarData[:26], encore.Fields(&l.LineData))
+		}
+		for _, s := range l.Staffs {
+			c.add("LineStaffData", s.Raw, encore.Fields(s))
+		}
+	}
+	for _, m := range d.Measures {
+		c.add("Measure", m.Raw, encore.Fields(m))
+		for _, e := range m.Elems {
+			c.add(elemStructName(e), e.Raw, elemFields(e))
+		}
+	}
+}
+
+// walk adds all .enc files below dir.
+func (c *coverageReport) walk(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".enc") {
+			return nil
+		}
+		c.files++
+		d, err := readEnc(path)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			c.failed++
+			return nil
+		}
+		c.addData(d)
+		return nil
+	})
+}
+
+// histogram formats the most frequent values of h.
+func histogram(h map[byte]int) string {
+	var vals []int
+	for v := range h {
+		vals = append(vals, int(v))
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		a, b := h[byte(vals[i])], h[byte(vals[j])]
+		return a > b || a == b && vals[i] < vals[j]
+	})
+	var s []string
+	for i, v := range vals {
+		if i == coverageTop {
+			s = append(s, fmt.Sprintf("(%d more)", len(vals)-i))
+			break
+		}
+		s = append(s, fmt.Sprintf("%02x:%d", v, h[byte(v)]))
+	}
+	if len(vals) == 1 {
+		s = append(s, "(constant)")
+	}
+	return strings.Join(s, " ")
+}
+
+func (s *structCoverage) write(w io.Writer) {
+	fmt.Fprintf(w, "%s: %d instances, up to %d bytes, %d mapped bytes\n", s.name, s.count, s.size, len(s.fields))
+	for off := 0; off < s.size; {
+		if f, ok := s.fields[off]; ok {
+			fmt.Fprintf(w, "  %4d  %s (%d bytes)\n", off, f.Name, f.Size)
+			off = f.Offset + f.Size
+			continue
+		}
+		if h := s.hist[off]; h != nil {
+			fmt.Fprintf(w, "  %4d  ? %s\n", off, histogram(h))
+		}
+		off++
+	}
+}
+
+func (c *coverageReport) write(w io.Writer) {
+	fmt.Fprintf(w, "%d files, %d failed to parse\n", c.files, c.failed)
+	var names []string
+	for n := range c.structs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintln(w)
+		c.structs[n].write(w)
+	}
+}
+
+// coverage reports, for each struct type in the .enc files below
+// the given directories, which offsets have a field and which values
+// occur at the other offsets.
+func coverage(args []string) {
+	c := newCoverageReport()
+	for _, dir := range args {
+		if err := c.walk(dir); err != nil {
+			log.Fatalf("walk %s: %v", dir, err)
+		}
+	}
+	c.write(os.Stdout)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestCoverageAdd(t *testing.T) {
+	c := newCoverageReport()
+	fields := []encore.Field{{Name: "A", Offset: 0, Size: 2}, {Name: "B", Offset: 3, Size: 1}}
+	c.add("S", []byte{1, 2, 7, 4, 9}, fields)
+	c.add("S", []byte{1, 2, 7, 4, 8, 5}, fields)
+	c.add("S", []byte{1, 2, 6, 4, 8}, fields)
+
+	s := c.structs["S"]
+	if s.count != 3 || s.size != 6 || len(s.fields) != 3 {
+		t.Fatalf("got count %d, size %d, %d mapped bytes", s.count, s.size, len(s.fields))
+	}
+	for off, want := range map[int]map[byte]int{
+		2: {7: 2, 6: 1},
+		4: {9: 1, 8: 2},
+		5: {5: 1},
+	} {
+		h := s.hist[off]
+		if len(h) != len(want) {
+			t.Errorf("offset %d: got %v, want %v", off, h, want)
+		}
+		for v, n := range want {
+			if h[v] != n {
+				t.Errorf("offset %d: got %v, want %v", off, h, want)
+			}
+		}
+	}
+	for _, off := range []int{0, 1, 3} {
+		if s.hist[off] != nil {
+			t.Errorf("offset %d is mapped, got histogram %v", off, s.hist[off])
+		}
+	}
+
+	var buf bytes.Buffer
+	s.write(&buf)
+	want := []string{
+		"S: 3 instances, up to 6 bytes, 3 mapped bytes",
+		"     0  A (2 bytes)",
+		"     2  ? 07:2 06:1",
+		"     3  B (1 bytes)",
+		"     4  ? 08:2 09:1",
+		"     5  ? 05:1 (constant)",
+	}
+	if got := strings.TrimSuffix(buf.String(), "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := map[byte]int{}
+	for i := 0; i < 8; i++ {
+		h[byte(i)] = 1
+	}
+	h[5] = 3
+	if got, want := histogram(h), "05:3 00:1 01:1 02:1 03:1 04:1 (2 more)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCoverageAddData(t *testing.T) {
+	d := testScore(t, 1, 1,
+		testElem{0, 0, 0, 0, testRest(3)},
+		testElem{0, 240, 0, 0, testRest(3)},
+	)
+	c := newCoverageReport()
+	c.addData(d)
+	for name, count := range map[string]int{
+		"Header":        1,
+		"Staff":         1,
+		"Line":          1,
+		"LineStaffData": 1,
+		"Measure":       1,
+		"Rest":          2,
+	} {
+		s := c.structs[name]
+		if s == nil || s.count != count {
+			t.Errorf("%s: got %+v, want %d instances", name, s, count)
+		}
+	}
+	r := c.structs["Rest"]
+	if _, ok := r.fields[5]; !ok {
+		t.Errorf("Rest byte 5 (FaceValue) not mapped")
+	}
+	if h := r.hist[6]; h[0] != 2 {
+		t.Errorf("Rest byte 6: got %v, want 00:2", h)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// Severity says how much a diagnostic affects the output.
+type Severity int
+
+const (
+	// Info is for input that was converted, but may look odd.
+	Info Severity = iota
+	// Warning is for input that was dropped or changed.
+	Warning
+	// Error is for input that could not be converted sensibly.
+	Error
+)
+
+var severityNames = []string{"info", "warning", "error"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("severity %d", int(s))
+	}
+	return severityNames[s]
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic is a problem found during conversion, with the position
+// of the element that caused it. Positions that are not known are -1.
+type Diagnostic struct {
+	Severity Severity
+	Measure  int
+	Staff    int
+	Voice    int
+
+	// Tick is relative to the start of the measure.
+	Tick int
+
+	// Offset is the byte offset of the element in the file.
+	Offset  int
+	Message string
+}
+
+func (d *Diagnostic) String() string {
+	s := d.Severity.String() + ":"
+	for _, p := range []struct {
+		name string
+		val  int
+	}{
+		{"meas", d.Measure},
+		{"staff", d.Staff},
+		{"voice", d.Voice},
+		{"tick", d.Tick},
+		{"offset", d.Offset},
+	} {
+		if p.val >= 0 {
+			s += fmt.Sprintf(" %s %d", p.name, p.val)
+		}
+	}
+	return s + ": " + d.Message
+}
+
+// Diagnostics collects the diagnostics of a conversion. A nil
+// *Diagnostics discards them.
+type Diagnostics struct {
+	Entries []*Diagnostic
+}
+
+// Add records a diagnostic for element e.
+func (d *Diagnostics) Add(sev Severity, e *encore.MeasElem, format string, args ...interface{}) {
+	if d == nil {
+		return
+	}
+	entry := &Diagnostic{
+		Severity: sev,
+		Staff:    e.GetStaff(),
+		Voice:    e.Voice(),
+		Tick:     e.GetTick(),
+		Offset:   e.Offset,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if e.Measure != nil {
+		entry.Measure = e.Measure.Id
+	}
+	d.Entries = append(d.Entries, entry)
+}
+
+// AddParseError records an anomaly found while decoding.
+func (d *Diagnostics) AddParseError(sev Severity, pe *encore.ParseError) {
+	if d == nil {
+		return
+	}
+	entry := &Diagnostic{
+		Severity: sev,
+		Measure:  -1,
+		Staff:    -1,
+		Voice:    -1,
+		Tick:     -1,
+		Offset:   pe.Offset,
+		Message:  fmt.Sprintf("%s %d: %s", pe.Tag, pe.Index, pe.Msg),
+	}
+	if pe.Tag == "MEAS" {
+		entry.Measure = pe.Index
+		entry.Message = pe.Msg
+	}
+	d.Entries = append(d.Entries, entry)
+}
+
+// summaryMax is the maximum number of entries listed by WriteSummary.
+const summaryMax = 20
+
+// WriteSummary writes the first entries and the number of entries per
+// severity.
+func (d *Diagnostics) WriteSummary(w io.Writer) {
+	if d == nil || len(d.Entries) == 0 {
+		return
+	}
+	counts := make([]int, len(severityNames))
+	for i, e := range d.Entries {
+		if i < summaryMax {
+			fmt.Fprintln(w, e)
+		}
+		if e.Severity >= 0 && int(e.Severity) < len(counts) {
+			counts[e.Severity]++
+		}
+	}
+	if n := len(d.Entries) - summaryMax; n > 0 {
+		fmt.Fprintf(w, "... and %d more\n", n)
+	}
+	fmt.Fprintf(w, "errors: %d, warnings: %d, info: %d\n", counts[Error], counts[Warning], counts[Info])
+}
+
+// WriteJSON writes the entries as a JSON array.
+func (d *Diagnostics) WriteJSON(w io.Writer) error {
+	entries := []*Diagnostic{}
+	if d != nil && d.Entries != nil {
+		entries = d.Entries
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
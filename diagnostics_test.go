@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestBadFaceValue(t *testing.T) {
+	d := testScore(t, 2, 2,
+		testElem{0, 0, 0, 0, testNote(1, 0, 60)},
+		testElem{1, 0, 1, 2, testRest(3)},
+		testElem{1, 240, 1, 2, testRest(0xf)},
+		testElem{1, 480, 1, 2, testRest(0x13)},
+		testElem{1, 720, 1, 2, testNote(0x2b, 0, 60)},
+	)
+	bad := d.Measures[1].Elems[1]
+	badNote := d.Measures[1].Elems[3]
+	checkDiagnostics(t, d, map[string]bool{}, []Diagnostic{
+		{Error, 1, 1, 2, 240, bad.Offset, "face value 15 out of range; skipped"},
+		{Error, 1, 1, 2, 720, badNote.Offset, "face value 11 out of range; skipped"},
+	})
+
+	// The notehead type in the high nibble is ignored.
+	if got := convertRest(d.Measures[1].Elems[2].TypeSpecific.(*encore.Rest)); got.DurationLog != 2 {
+		t.Errorf("got duration log %d, want 2", got.DurationLog)
+	}
+}
+
+// checkDiagnostics converts d to all formats but those in skip, and
+// checks that each gives the diagnostics want.
+func checkDiagnostics(t *testing.T, d *encore.Data, skip map[string]bool, want []Diagnostic) {
+	for name, write := range formats {
+		if skip[name] {
+			continue
+		}
+		var diag Diagnostics
+		if err := write(ioutil.Discard, d, &diag); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if len(diag.Entries) != len(want) {
+			t.Errorf("%s: got %v, want %v", name, diag.Entries, want)
+			continue
+		}
+		for i, e := range diag.Entries {
+			if *e != want[i] {
+				t.Errorf("%s: got %+v, want %+v", name, *e, want[i])
+			}
+		}
+	}
+}
+
+func TestBadAlteration(t *testing.T) {
+	// E flat and E triple sharp on the bottom line in the treble
+	// clef, and G triple flat in the bass clef.
+	d := testScore(t, 1, 1,
+		testElem{0, 0, 0, 0, testNote(3, 2, 63)},
+		testElem{0, 240, 0, 0, testNote(3, 2, 67)},
+		testElem{0, 480, 0, 0, &encore.Clef{ClefType: 1}},
+		testElem{0, 480, 0, 0, testNote(3, 2, 40)},
+	)
+	es := d.Measures[0].Elems
+	// MIDI plays the pitch, so the alteration does not matter.
+	checkDiagnostics(t, d, map[string]bool{"midi": true}, []Diagnostic{
+		{Error, 0, 0, 0, 240, es[1].Offset, "alteration 3 out of range; skipped"},
+		{Error, 0, 0, 0, 480, es[3].Offset, "alteration -3 out of range; skipped"},
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	d := testScore(t, 1, 2,
+		testElem{1, 120, 0, 3, testRest(4)},
+	)
+	e := d.Measures[1].Elems[0]
+
+	var nilDiag *Diagnostics
+	nilDiag.Add(Error, e, "dropped")
+	nilDiag.AddParseError(Warning, &encore.ParseError{})
+	nilDiag.WriteSummary(ioutil.Discard)
+
+	diag := &Diagnostics{}
+	diag.Add(Warning, e, "odd %s", "rest")
+	diag.AddParseError(Info, &encore.ParseError{Tag: "MEAS", Index: 1, Offset: 99, Msg: "short"})
+	diag.AddParseError(Error, &encore.ParseError{Tag: "LINE", Index: 0, Offset: 50, Msg: "bad size"})
+
+	want := []string{
+		fmt.Sprintf("warning: meas 1 staff 0 voice 3 tick 120 offset %d: odd rest", e.Offset),
+		"info: meas 1 offset 99: short",
+		"error: offset 50: LINE 0: bad size",
+	}
+	for i, entry := range diag.Entries {
+		if got := entry.String(); got != want[i] {
+			t.Errorf("%d: got %q, want %q", i, got, want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	diag.WriteSummary(&buf)
+	summary := strings.Join(append(want, "errors: 1, warnings: 1, info: 1"), "\n") + "\n"
+	if got := buf.String(); got != summary {
+		t.Errorf("got summary\n%s\nwant\n%s", got, summary)
+	}
+
+	buf.Reset()
+	if err := diag.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	first := entries[0]
+	for k, v := range map[string]interface{}{
+		"Severity": "warning",
+		"Measure":  1.0,
+		"Staff":    0.0,
+		"Voice":    3.0,
+		"Tick":     120.0,
+		"Offset":   float64(e.Offset),
+		"Message":  "odd rest",
+	} {
+		if first[k] != v {
+			t.Errorf("%s: got %v, want %v", k, first[k], v)
+		}
+	}
+
+	buf.Reset()
+	if err := nilDiag.WriteJSON(&buf); err != nil || buf.String() != "[]\n" {
+		t.Errorf("got %q, %v for no entries", buf.String(), err)
+	}
+}
+
+func TestWriteSummaryMax(t *testing.T) {
+	d := testScore(t, 1, 1, testElem{0, 0, 0, 0, testRest(3)})
+	diag := &Diagnostics{}
+	for i := 0; i < summaryMax+3; i++ {
+		diag.Add(Info, d.Measures[0].Elems[0], "entry %d", i)
+	}
+	var buf bytes.Buffer
+	diag.WriteSummary(&buf)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != summaryMax+2 {
+		t.Fatalf("got %d lines, want %d", len(lines), summaryMax+2)
+	}
+	if got := lines[summaryMax]; got != "... and 3 more" {
+		t.Errorf("got %q", got)
+	}
+	if got := lines[summaryMax+1]; got != fmt.Sprintf("errors: 0, warnings: 0, info: %d", summaryMax+3) {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// dataDiff compares two parsed files. Blocks are matched by index,
+// and the elements of a measure by staff, voice, tick and type, so
+// an inserted element only shows up as an addition.
+type dataDiff struct {
+	w     io.Writer
+	a, b  *encore.Data
+	found bool
+}
+
+func (d *dataDiff) printf(format string, args ...interface{}) {
+	d.found = true
+	fmt.Fprintf(d.w, format, args...)
+}
+
+func hexBytes(b []byte) string {
+	var s []string
+	for _, c := range b {
+		s = append(s, fmt.Sprintf("%02x", c))
+	}
+	return strings.Join(s, " ")
+}
+
+func sameLocation(x, y *encore.Location) bool {
+	return x.Struct == y.Struct && x.Field == y.Field && x.Elem == y.Elem
+}
+
+// bytes compares the block name, which is at offA in a and at offB in
+// b. Changed fields are printed with their old and new value, changed
+// unmapped bytes with their position in the struct covering them.
+func (d *dataDiff) bytes(name string, offA, sizeA, offB, sizeB int) {
+	rawA := d.a.Raw[offA : offA+sizeA]
+	rawB := d.b.Raw[offB : offB+sizeB]
+	if string(rawA) == string(rawB) {
+		return
+	}
+	var lines []string
+	if sizeA != sizeB {
+		lines = append(lines, fmt.Sprintf("size: %d -> %d", sizeA, sizeB))
+	}
+	n := sizeA
+	if sizeB < n {
+		n = sizeB
+	}
+	for i := 0; i < n; {
+		loc := d.a.Locate(offA + i)
+		j := i + 1
+		for j < n && sameLocation(loc, d.a.Locate(offA+j)) {
+			j++
+		}
+		if loc.Field != "" {
+			x, y := rawA[i:j], rawB[i:j]
+			if string(x) != string(y) {
+				label := loc.Struct + "." + loc.Field
+				if j-i <= 4 {
+					lines = append(lines, fmt.Sprintf("%s: %d -> %d", label, leValue(x), leValue(y)))
+				} else {
+					lines = append(lines, fmt.Sprintf("%s: %s -> %s", label, hexBytes(x), hexBytes(y)))
+				}
+			}
+			i = j
+			continue
+		}
+		for k := i; k < j; {
+			if rawA[k] == rawB[k] {
+				k++
+				continue
+			}
+			e := k + 1
+			for e < j && rawA[e] != rawB[e] {
+				e++
+			}
+			lines = append(lines, fmt.Sprintf("%s byte %d: %s -> %s (unmapped)",
+				loc.Struct, loc.Rel+k-i, hexBytes(rawA[k:e]), hexBytes(rawB[k:e])))
+			k = e
+		}
+		i = j
+	}
+	if len(lines) > 0 {
+		d.printf("%s:\n", name)
+		for _, l := range lines {
+			d.printf("  %s\n", l)
+		}
+	}
+}
+
+// count reports a change in the number of blocks of a kind.
+func (d *dataDiff) count(what string, na, nb int) int {
+	if na != nb {
+		d.printf("%s count: %d -> %d\n", what, na, nb)
+	}
+	if nb < na {
+		return nb
+	}
+	return na
+}
+
+type elemKey struct {
+	staff, voice, tick, typ int
+}
+
+func keyOf(e *encore.MeasElem) elemKey {
+	return elemKey{e.GetStaff(), e.Voice(), e.GetTick(), e.Type()}
+}
+
+func elemString(e *encore.MeasElem) string {
+	name := "?"
+	if e.TypeSpecific != nil {
+		name = e.GetTypeName()
+	}
+	return fmt.Sprintf("%s staff %d voice %d tick %d", name, e.GetStaff(), e.Voice(), e.GetTick())
+}
+
+// elems compares the elements of measure i.
+func (d *dataDiff) elems(i int, ma, mb *encore.Measure) {
+	unmatched := map[elemKey][]int{}
+	for j, e := range mb.Elems {
+		k := keyOf(e)
+		unmatched[k] = append(unmatched[k], j)
+	}
+	matched := make([]bool, len(mb.Elems))
+	for j, e := range ma.Elems {
+		k := keyOf(e)
+		cands := unmatched[k]
+		if len(cands) == 0 {
+			d.printf("- meas %d elem %d: %s\n", i, j, elemString(e))
+			continue
+		}
+		jb := cands[0]
+		unmatched[k] = cands[1:]
+		matched[jb] = true
+		name := fmt.Sprintf("meas %d elem %d", i, j)
+		if jb != j {
+			name += fmt.Sprintf(" (elem %d in b)", jb)
+		}
+		name += ": " + elemString(e)
+		f := mb.Elems[jb]
+		d.bytes(name, e.Offset, len(e.Raw), f.Offset, len(f.Raw))
+	}
+	for j, e := range mb.Elems {
+		if !matched[j] {
+			d.printf("+ meas %d elem %d: %s\n", i, j, elemString(e))
+		}
+	}
+}
+
+// run compares a and b, and returns whether they differ.
+func (d *dataDiff) run() bool {
+	a, b := d.a, d.b
+	d.bytes("header", a.Header.Offset, len(a.Header.Raw), b.Header.Offset, len(b.Header.Raw))
+	for i, n := 0, d.count("staff", len(a.Staff), len(b.Staff)); i < n; i++ {
+		s, t := a.Staff[i], b.Staff[i]
+		d.bytes(fmt.Sprintf("staff %d (%s)", i, s.DisplayName()), s.Offset, len(s.Raw), t.Offset, len(t.Raw))
+	}
+	for i, n := 0, d.count("page", len(a.Pages), len(b.Pages)); i < n; i++ {
+		p, q := a.Pages[i], b.Pages[i]
+		d.bytes(fmt.Sprintf("page %d", i), p.Offset, len(p.Raw), q.Offset, len(q.Raw))
+	}
+	for i, n := 0, d.count("line", len(a.Lines), len(b.Lines)); i < n; i++ {
+		l, m := a.Lines[i], b.Lines[i]
+		d.bytes(fmt.Sprintf("line %d", i), l.Offset, len(l.Raw)+len(l.VarData), m.Offset, len(m.Raw)+len(m.VarData))
+	}
+	for i, n := 0, d.count("measure", len(a.Measures), len(b.Measures)); i < n; i++ {
+		m, k := a.Measures[i], b.Measures[i]
+		d.bytes(fmt.Sprintf("meas %d", i), m.Offset, len(m.Raw), k.Offset, len(k.Raw))
+		d.elems(i, m, k)
+	}
+	for i, n := 0, d.count("section", len(a.Extra), len(b.Extra)); i < n; i++ {
+		s, t := a.Extra[i], b.Extra[i]
+		name := fmt.Sprintf("section %d (%q)", i, s.Tag)
+		if s.Tag != t.Tag {
+			d.printf("%s: tag %q in b\n", name, t.Tag)
+			continue
+		}
+		d.bytes(name, s.Offset, len(s.Raw), t.Offset, len(t.Raw))
+	}
+	return d.found
+}
+
+// diff prints the differences between two Encore files. It exits
+// with status 1 if they differ.
+func diff(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("diff: need 2 files, got %d", len(args))
+	}
+	var data [2]*encore.Data
+	for i, name := range args {
+		d, err := readEnc(name)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		data[i] = d
+	}
+	d := &dataDiff{w: os.Stdout, a: data[0], b: data[1]}
+	if d.run() {
+		os.Exit(1)
+	}
+}
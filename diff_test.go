@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestDataDiff(t *testing.T) {
+	a := testScore(t, 1, 1,
+		testElem{0, 0, 0, 0, testNote(3, 4, 67)},
+		testElem{0, 240, 0, 0, testRest(3)},
+	)
+	var buf bytes.Buffer
+	if (&dataDiff{w: &buf, a: a, b: a}).run() || buf.Len() > 0 {
+		t.Errorf("file differs from itself:\n%s", buf.String())
+	}
+
+	rest := a.Measures[0].Elems[1]
+	for _, tc := range []struct {
+		rel  int
+		want string
+	}{
+		{5, "meas 0 elem 1: Rest staff 0 voice 0 tick 240:\n  Rest.FaceValue: 3 -> 4\n"},
+		{6, "meas 0 elem 1: Rest staff 0 voice 0 tick 240:\n  Rest byte 6: 00 -> 01 (unmapped)\n"},
+	} {
+		raw := append([]byte(nil), a.Raw...)
+		raw[rest.Offset+tc.rel]++
+		b, err := encore.ReadData(raw)
+		if err != nil {
+			t.Fatalf("ReadData: %v", err)
+		}
+		buf.Reset()
+		if !(&dataDiff{w: &buf, a: a, b: b}).run() {
+			t.Errorf("byte %d: no difference found", tc.rel)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("byte %d: got\n%s\nwant\n%s", tc.rel, got, tc.want)
+		}
+	}
+}
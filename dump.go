@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// The dump types mirror encore.Data without the back pointers, so
+// it can be written as JSON. Fields has the values of the fields
+// with an offset tag, keyed by name.
+
+type dumpBlock struct {
+	Offset int
+	Raw    string
+	Fields map[string]interface{}
+}
+
+type dumpStaff struct {
+	Id   int
+	Name string
+	dumpBlock
+}
+
+type dumpPage struct {
+	Id int
+	dumpBlock
+}
+
+type dumpLineStaff struct {
+	Id     int
+	Raw    string
+	Fields map[string]interface{}
+}
+
+type dumpLine struct {
+	Id int
+	dumpBlock
+	VarData string
+	Staffs  []dumpLineStaff
+}
+
+type dumpElem struct {
+	Index   int
+	Type    string
+	Offset  int
+	Tick    int
+	AbsTick int
+	Staff   int
+	Voice   int
+	Raw     string
+	Fields  map[string]interface{}
+
+	SubBeams []map[string]interface{} `json:",omitempty"`
+}
+
+type dumpMeasure struct {
+	Id      int
+	AbsTick int
+	dumpBlock
+	Elems []dumpElem
+}
+
+type dumpSection struct {
+	Tag    string
+	Offset int
+	Size   int
+	Raw    string
+}
+
+type dumpData struct {
+	Version string
+	Charset int
+	Text    []string
+
+	Header   dumpBlock
+	Staff    []dumpStaff
+	Pages    []dumpPage
+	Lines    []dumpLine
+	Measures []dumpMeasure
+	Extra    []dumpSection
+}
+
+// fieldValues returns the values of the offset fields of the struct
+// pointed to by v.
+func fieldValues(v interface{}) map[string]interface{} {
+	r := map[string]interface{}{}
+	val := reflect.ValueOf(v).Elem()
+	for _, f := range encore.Fields(v) {
+		r[f.Name] = val.FieldByName(f.Name).Interface()
+	}
+	return r
+}
+
+func newDumpBlock(v interface{}, off int, raw []byte) dumpBlock {
+	return dumpBlock{
+		Offset: off,
+		Raw:    hex.EncodeToString(raw),
+		Fields: fieldValues(v),
+	}
+}
+
+func newDumpElem(i int, e *encore.MeasElem) dumpElem {
+	r := dumpElem{
+		Index:   i,
+		Offset:  e.Offset,
+		Tick:    e.GetTick(),
+		AbsTick: e.AbsTick(),
+		Staff:   e.GetStaff(),
+		Voice:   e.Voice(),
+		Raw:     hex.EncodeToString(e.Raw),
+		Fields:  fieldValues(e),
+	}
+	if e.TypeSpecific == nil {
+		return r
+	}
+	r.Type = e.GetTypeName()
+	for k, v := range fieldValues(e.TypeSpecific) {
+		r.Fields[k] = v
+	}
+	if b, ok := e.TypeSpecific.(*encore.Beam); ok {
+		for j := range b.SubBeams {
+			r.SubBeams = append(r.SubBeams, fieldValues(&b.SubBeams[j]))
+		}
+	}
+	return r
+}
+
+func newDump(d *encore.Data) *dumpData {
+	r := &dumpData{
+		Charset: int(d.Charset),
+		Text:    d.Text,
+		Header:  newDumpBlock(&d.Header, d.Header.Offset, d.Header.Raw),
+	}
+	if d.Layout != nil {
+		r.Version = d.Layout.Version
+	}
+	for _, s := range d.Staff {
+		r.Staff = append(r.Staff, dumpStaff{
+			Id:        s.Id,
+			Name:      s.DisplayName(),
+			dumpBlock: newDumpBlock(s, s.Offset, s.Raw),
+		})
+	}
+	for _, p := range d.Pages {
+		r.Pages = append(r.Pages, dumpPage{p.Id, newDumpBlock(p, p.Offset, p.Raw)})
+	}
+	for _, l := range d.Lines {
+		dl := dumpLine{
+			Id:        l.Id,
+			dumpBlock: newDumpBlock(l, l.Offset, l.Raw),
+			VarData:   hex.EncodeToString(l.VarData),
+		}
+		for j, s := range l.Staffs {
+			dl.Staffs = append(dl.Staffs, dumpLineStaff{j, hex.EncodeToString(s.Raw), fieldValues(s)})
+		}
+		r.Lines = append(r.Lines, dl)
+	}
+	for _, m := range d.Measures {
+		dm := dumpMeasure{
+			Id:        m.Id,
+			AbsTick:   m.AbsTick,
+			dumpBlock: newDumpBlock(m, m.Offset, m.Raw),
+		}
+		for i, e := range m.Elems {
+			dm.Elems = append(dm.Elems, newDumpElem(i, e))
+		}
+		r.Measures = append(r.Measures, dm)
+	}
+	for _, s := range d.Extra {
+		r.Extra = append(r.Extra, dumpSection{s.Tag, s.Offset, s.Size, hex.EncodeToString(s.Raw)})
+	}
+	return r
+}
+
+// WriteDump writes the parsed structure of d as JSON.
+func WriteDump(w io.Writer, d *encore.Data) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newDump(d))
+}
+
+// readEnc reads and parses the Encore file name with decodeOptions.
+// The complete input is kept, as the commands show and change the
+// raw bytes.
+func readEnc(name string) (*encore.Data, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	opts := *decodeOptions
+	opts.KeepRaw = true
+	return encore.Decode(f, &opts)
+}
+
+// dump writes the parsed structure of an Encore file as JSON.
+func dump(args []string) {
+	for _, name := range args {
+		d, err := readEnc(name)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		if err := WriteDump(os.Stdout, d); err != nil {
+			log.Fatalf("dump: %v", err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestWriteDump(t *testing.T) {
+	d := testScore(t, 3, 1,
+		testElem{0, 0, 1, 0, testNote(3, 4, 67)},
+		testElem{0, 240, 1, 0, testRest(3)},
+	)
+	var buf bytes.Buffer
+	if err := WriteDump(&buf, d); err != nil {
+		t.Fatalf("WriteDump: %v", err)
+	}
+	var got dumpData
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal: %v\n%s", err, buf.String())
+	}
+
+	if len(got.Staff) != 3 || got.Staff[2].Id != 2 || got.Staff[2].Name != "Staff 3" {
+		t.Errorf("got staves %+v", got.Staff)
+	}
+	if len(got.Lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(got.Lines))
+	}
+	for i, s := range got.Lines[0].Staffs {
+		if s.Id != i {
+			t.Errorf("line staff %d: got Id %d", i, s.Id)
+		}
+		if idx := s.Fields["StaffIdx"]; idx != float64(i) {
+			t.Errorf("line staff %d: got StaffIdx %v", i, idx)
+		}
+	}
+	if len(got.Lines[0].Staffs) != 3 {
+		t.Errorf("got %d line staves, want 3", len(got.Lines[0].Staffs))
+	}
+
+	if len(got.Measures) != 1 {
+		t.Fatalf("got %d measures, want 1", len(got.Measures))
+	}
+	elems := got.Measures[0].Elems
+	if len(elems) != 2 {
+		t.Fatalf("got %d elements, want 2", len(elems))
+	}
+	for i, want := range []struct {
+		typ         string
+		tick, staff int
+	}{
+		{"Note", 0, 1},
+		{"Rest", 240, 1},
+	} {
+		e := elems[i]
+		if e.Index != i || e.Type != want.typ || e.Tick != want.tick || e.Staff != want.staff {
+			t.Errorf("elem %d: got %+v, want %+v", i, e, want)
+		}
+		if e.Offset != d.Measures[0].Elems[i].Offset {
+			t.Errorf("elem %d: got offset %d, want %d", i, e.Offset, d.Measures[0].Elems[i].Offset)
+		}
+	}
+	if p := elems[0].Fields["SemitonePitch"]; p != float64(67) {
+		t.Errorf("got SemitonePitch %v, want 67", p)
+	}
+}
+
+func TestReadEnc(t *testing.T) {
+	raw := badFiles(t)["tuplet"]
+	name := filepath.Join(t.TempDir(), "tuplet.enc")
+	if err := ioutil.WriteFile(name, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(o *encore.DecodeOptions) { decodeOptions = o }(decodeOptions)
+
+	decodeOptions = &encore.DecodeOptions{}
+	d, err := readEnc(name)
+	if err != nil {
+		t.Fatalf("readEnc: %v", err)
+	}
+	if !bytes.Equal(d.Raw, raw) || len(d.Anomalies) != 1 {
+		t.Errorf("got %d raw bytes, anomalies %v", len(d.Raw), d.Anomalies)
+	}
+
+	for _, opts := range []*encore.DecodeOptions{
+		{Mode: encore.Strict},
+		{MaxSize: int64(len(raw) - 1)},
+	} {
+		decodeOptions = opts
+		if _, err := readEnc(name); err == nil {
+			t.Errorf("%+v: readEnc succeeded", *opts)
+		}
+	}
+}
@@ -1,23 +1,49 @@
 package encore
 
+//go:generate go run gen_decoders.go
+
 import (
 	"fmt"
+	"strings"
 )
 
 
 type Data struct {
 	Raw      []byte
+	Layout   *Layout
+	Charset  Charset
 	Header   Header
 	Staff    []*Staff
 	Pages    []*Page
 	Lines    []*Line
 	Measures []*Measure
+
+	// Sections following the last measure.
+	Extra []*Section
+
+	// Strings from the TEXT sections, in file order.
+	Text []string
+
+	// Anomalies in the input that were skipped, in Normal or
+	// Lenient mode.
+	Anomalies []*ParseError
+
+	// Measures without decoded elements, if decoded with
+	// LazyMeasures.
+	measureIndex []*Measure
+	loaded       []bool
+
+	mode Mode
 }
 
 type Header struct {
 	Offset int
 	Raw    []byte `want:"SCOW" fixed:"194"`
 
+	// FormatVersion is the major version of the file format; see
+	// Layout.FormatVersions.
+	FormatVersion byte `offset:"0x4"`
+
 	LineCount      int16 `offset:"0x2e"`
 	PageCount      int16 `offset:"0x30"`
 	StaffCount     byte  `offset:"0x32"`
@@ -45,6 +71,7 @@ type Page struct {
 
 type LineStaffData struct {
 	Id        int
+	Raw       []byte
 	Clef      byte `offset:"1"`
 	Key       byte `offset:"2"`
 	PageIdx   byte `offset:"3"`
@@ -94,6 +121,7 @@ type Staff struct {
 	// Sometimes TK00, sometimes TK01
 	Raw     []byte `fixed:"242"`
 
+	// See DisplayName.
 	Name [10]byte `offset:"8"`
 
 	// 174, 175, 
@@ -108,11 +136,38 @@ type Staff struct {
 	// Other candidates: 169, 170, 177
 	PianoStaff byte `offset:"181"`
 
-	// 188 - 212: MIDI channel (repeated?), MIDI program (repeated?), MIDI volume (repeated?)
+	// 188 - 212: MIDI channel, program and volume. Repeated 8
+	// times, presumably once per voice.
+	MidiChannel [8]byte `offset:"188"`
+	MidiProgram [8]byte `offset:"196"`
+	MidiVolume  [8]byte `offset:"204"`
 
 	// 164 ?
 
 	// 205 ?
+
+	charset Charset
+}
+
+// Channel returns the MIDI channel (0 - 15) for voice.
+func (s *Staff) Channel(voice int) int {
+	return int(s.MidiChannel[voice&7] & 0xf)
+}
+
+// Program returns the General MIDI program (0 - 127) for voice.
+func (s *Staff) Program(voice int) int {
+	return int(s.MidiProgram[voice&7] & 0x7f)
+}
+
+// Volume returns the MIDI volume (0 - 127) for voice.
+func (s *Staff) Volume(voice int) int {
+	return int(s.MidiVolume[voice&7] & 0x7f)
+}
+
+// DisplayName returns the staff name as UTF-8. Only the 10 bytes of
+// Name are used: whether longer names continue past them is not known.
+func (s *Staff) DisplayName() string {
+	return strings.TrimSpace(s.charset.Decode(s.Name[:]))
 }
 
 type MeasElemSpecific interface {
@@ -169,6 +224,22 @@ func (n *MeasElem) GetDurationTick() int {
 	return n.TypeSpecific.GetDurationTick()
 }
 
+// Problem returns why the values of n cannot be used, eg. a key out of
+// range, or "" if they can.
+func (n *MeasElem) Problem() string {
+	switch t := n.TypeSpecific.(type) {
+	case *KeyChange:
+		if t.NewKey > MaxKey {
+			return fmt.Sprintf("key %d out of range", t.NewKey)
+		}
+	case *Note:
+		return t.problem()
+	case *Rest:
+		return t.problem()
+	}
+	return ""
+}
+
 func (n *MeasElem) Voice() int {
 	return int(n.TypeVoice & 0xf)
 }
@@ -266,6 +337,10 @@ func (o *Slur) GetTypeName() string {
 	return "Slur"
 }
 
+// MaxKey is the highest key: keys 0 to 7 have 0 to 7 flats, and
+// keys 8 to 14 have 1 to 7 sharps.
+const MaxKey = 14
+
 type KeyChange struct {
 	NoDuration
 	NewKey byte `offset:"5"`
@@ -339,6 +414,10 @@ type WithDuration struct {
 	PlaybackDurationTicks uint16 `offset:"16"`
 }
 
+// MaxFaceValue is the face value of a 128th. Face value 0 is a
+// breve.
+const MaxFaceValue = 8
+
 func (w *WithDuration) TupletDen() int {
 	return int(w.Tuplet >> 4)
 }
@@ -377,6 +456,16 @@ func (w *WithDuration) DurationLog() int {
 	return int(w.FaceValue&0xf) - 1
 }
 
+func (w *WithDuration) problem() string {
+	if fv := w.FaceValue & 0xf; fv > MaxFaceValue {
+		return fmt.Sprintf("face value %d out of range", fv)
+	}
+	if w.Tuplet != 0 && w.TupletDen() == 0 {
+		return fmt.Sprintf("tuplet %d/0 has no denominator", w.TupletNum())
+	}
+	return ""
+}
+
 type Rest struct {
 	WithDuration
 	XOffset  byte `offset:"10"`
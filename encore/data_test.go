@@ -17,3 +17,52 @@ func TestWithDuration(t *testing.T) {
 			w, got, want)
 	}
 }
+
+func TestDisplayName(t *testing.T) {
+	raw := make([]byte, 242)
+	copy(raw[8:], "Violoncello I\x00")
+	s := &Staff{Raw: raw}
+	copy(s.Name[:], raw[8:])
+	if got, want := s.DisplayName(), "Violoncell"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	s = &Staff{}
+	copy(s.Name[:], "Fl\xfbte\x00")
+	if got, want := s.DisplayName(), "Flûte"; got != want {
+		t.Errorf("Windows1252: got %q want %q", got, want)
+	}
+	s.charset = MacRoman
+	copy(s.Name[:], "Fl\x9ete\x00")
+	if got, want := s.DisplayName(), "Flûte"; got != want {
+		t.Errorf("MacRoman: got %q want %q", got, want)
+	}
+}
+
+func TestStaffMidi(t *testing.T) {
+	c := testFile()
+	staff := c[194:]
+	staff[188+1] = 0x13 // channel 3 for voice 1, high nibble ignored
+	staff[196] = 40     // violin
+	staff[196+1] = 0x80 + 42
+	staff[204] = 100
+	d, err := ReadData(c)
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	s := d.Staff[0]
+	for _, tc := range []struct {
+		voice                    int
+		channel, program, volume int
+	}{
+		{0, 0, 40, 100},
+		{1, 3, 42, 0},
+		{9, 3, 42, 0},
+	} {
+		ch, prog, vol := s.Channel(tc.voice), s.Program(tc.voice), s.Volume(tc.voice)
+		if ch != tc.channel || prog != tc.program || vol != tc.volume {
+			t.Errorf("voice %d: got channel %d program %d volume %d, want %d %d %d",
+				tc.voice, ch, prog, vol, tc.channel, tc.program, tc.volume)
+		}
+	}
+}
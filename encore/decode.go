@@ -0,0 +1,412 @@
+package encore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLimit is wrapped in the *ParseError returned by Decode if the
+// input exceeds one of the limits in DecodeOptions.
+var ErrLimit = errors.New("limit exceeded")
+
+// Mode says how Decode handles anomalies in the input.
+type Mode int
+
+const (
+	// Normal fails on malformed blocks and elements, and records
+	// smaller anomalies, like a missing end marker, in
+	// Data.Anomalies.
+	Normal Mode = iota
+
+	// Strict fails on every anomaly.
+	Strict
+
+	// Lenient records all anomalies it can recover from in
+	// Data.Anomalies. It drops elements with an unknown type or
+	// staff, and after a bad element or measure size it
+	// resynchronizes on the next plausible element or measure.
+	Lenient
+)
+
+// DecodeOptions controls Decode. Zero values mean no limit.
+type DecodeOptions struct {
+	// MaxSize is the maximum number of bytes read from the input.
+	MaxSize int64
+
+	// Maximum block counts, as announced in the header.
+	MaxStaffs   int
+	MaxPages    int
+	MaxLines    int
+	MaxMeasures int
+
+	// KeepRaw reads the complete input into Data.Raw. Otherwise,
+	// only the blocks themselves are retained.
+	KeepRaw bool
+
+	// Charset for decoding strings. The file does not say which
+	// platform wrote it.
+	Charset Charset
+
+	// LazyMeasures only reads the measure headers. The elements
+	// are decoded on demand by Data.Measure, and Data.Measures is
+	// left nil until LoadMeasures is called.
+	LazyMeasures bool
+
+	// Mode is the handling of anomalies. Lenient mode reads the
+	// complete input, as with KeepRaw.
+	Mode Mode
+}
+
+// Decode reads an Encore file from r. Malformed input, or input
+// exceeding the limits set in opts, results in a *ParseError. A nil
+// opts is equivalent to the zero DecodeOptions.
+func Decode(r io.Reader, opts *DecodeOptions) (*Data, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+
+	if !opts.KeepRaw && opts.Mode != Lenient {
+		d := &decoder{r: bufio.NewReader(r), opts: *opts}
+		return d.decode()
+	}
+
+	if opts.MaxSize > 0 {
+		r = io.LimitReader(r, opts.MaxSize+1)
+	}
+	c, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxSize > 0 && int64(len(c)) > opts.MaxSize {
+		return nil, &ParseError{
+			Offset: int(opts.MaxSize),
+			Msg:    fmt.Sprintf("input larger than %d bytes", opts.MaxSize),
+			Err:    ErrLimit,
+		}
+	}
+
+	d := &decoder{buf: c, opts: *opts}
+	f, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if opts.KeepRaw {
+		f.Raw = c
+	}
+	return f, nil
+}
+
+// decoder reads blocks sequentially, either from buf or, if r is
+// set, from r.
+type decoder struct {
+	buf    []byte
+	r      *bufio.Reader
+	off    int
+	opts   DecodeOptions
+	layout *Layout
+}
+
+// peek returns up to n bytes without consuming them.
+func (d *decoder) peek(n int) []byte {
+	if d.r == nil {
+		if d.off+n > len(d.buf) {
+			n = len(d.buf) - d.off
+		}
+		return d.buf[d.off : d.off+n]
+	}
+	b, _ := d.r.Peek(n)
+	return b
+}
+
+// next returns the next n bytes of the input. The tag and idx are
+// for error reporting.
+func (d *decoder) next(n int, tag string, idx int) ([]byte, error) {
+	if n < 0 {
+		return nil, &ParseError{
+			Tag:    tag,
+			Index:  idx,
+			Offset: d.off,
+			Msg:    fmt.Sprintf("negative size %d", n),
+		}
+	}
+	if d.opts.MaxSize > 0 && int64(d.off)+int64(n) > d.opts.MaxSize {
+		return nil, &ParseError{
+			Tag:    tag,
+			Index:  idx,
+			Offset: d.off,
+			Msg:    fmt.Sprintf("input larger than %d bytes", d.opts.MaxSize),
+			Err:    ErrLimit,
+		}
+	}
+
+	var raw []byte
+	if d.r == nil {
+		if d.off+n > len(d.buf) {
+			if tag == "" && d.off+4 <= len(d.buf) {
+				tag = string(d.buf[d.off : d.off+4])
+			}
+			return nil, &ParseError{
+				Tag:    tag,
+				Index:  idx,
+				Offset: d.off,
+				Msg:    fmt.Sprintf("truncated: need %d bytes, have %d", n, len(d.buf)-d.off),
+			}
+		}
+		raw = d.buf[d.off : d.off+n]
+	} else {
+		// The size comes from the file, so the buffer only grows
+		// with the bytes actually read.
+		var buf bytes.Buffer
+		if got, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, &ParseError{
+				Tag:    tag,
+				Index:  idx,
+				Offset: d.off,
+				Msg:    fmt.Sprintf("truncated: need %d bytes, have %d", n, got),
+				Err:    err,
+			}
+		}
+		raw = buf.Bytes()
+	}
+	d.off += n
+	return raw, nil
+}
+
+// rest returns the remainder of the input.
+func (d *decoder) rest() ([]byte, error) {
+	if d.r == nil {
+		raw := d.buf[d.off:]
+		d.off = len(d.buf)
+		return raw, nil
+	}
+
+	var r io.Reader = d.r
+	if d.opts.MaxSize > 0 {
+		r = io.LimitReader(r, d.opts.MaxSize-int64(d.off)+1)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.MaxSize > 0 && int64(d.off+len(raw)) > d.opts.MaxSize {
+		return nil, &ParseError{
+			Offset: d.off,
+			Msg:    fmt.Sprintf("input larger than %d bytes", d.opts.MaxSize),
+			Err:    ErrLimit,
+		}
+	}
+	d.off += len(raw)
+	return raw, nil
+}
+
+// remaining returns an upper bound for the number of bytes left.
+func (d *decoder) remaining() int {
+	if d.r == nil {
+		return len(d.buf) - d.off
+	}
+	if d.opts.MaxSize > 0 {
+		return int(d.opts.MaxSize) - d.off
+	}
+	return int(^uint(0) >> 1)
+}
+
+func (d *decoder) checkLimit(what string, n, max int) error {
+	if max > 0 && n > max {
+		return &ParseError{
+			Tag: "SCOW",
+			Msg: fmt.Sprintf("%d %s, maximum is %d", n, what, max),
+			Err: ErrLimit,
+		}
+	}
+	return nil
+}
+
+// findMeasure skips to the next MEAS tag for measure i, recording the
+// skipped bytes as an anomaly. It returns false if there is none. It
+// is only used in Lenient mode, which reads from buf.
+func (d *decoder) findMeasure(f *Data, i int) bool {
+	if bytes.HasPrefix(d.buf[d.off:], []byte("MEAS")) {
+		return true
+	}
+	j := bytes.Index(d.buf[d.off:], []byte("MEAS"))
+	pe := &ParseError{Tag: "MEAS", Index: i, Offset: d.off}
+	if j < 0 {
+		pe.Msg = "tag not found; dropping the remaining measures"
+		f.anomaly(pe)
+		return false
+	}
+	pe.Msg = fmt.Sprintf("tag not found; skipped %d bytes", j)
+	f.anomaly(pe)
+	d.off += j
+	return true
+}
+
+// measureSize returns the size of the elements of m, the measure
+// whose header was just read, out of n measures. If the size in the header does not end
+// at the next measure, or at the end of the input for the last
+// measure, it returns the size up to the next MEAS tag or end
+// marker instead. It is only used in Lenient mode.
+func (d *decoder) measureSize(f *Data, m *Measure, n int) int {
+	rest := d.buf[d.off:]
+	size := int(m.VarSize)
+	last := m.Id == n-1
+	if size >= 2 && size <= len(rest) && (last && string(rest[size-2:size]) == endMarker ||
+		!last && bytes.HasPrefix(rest[size:], []byte("MEAS"))) {
+		return size
+	}
+
+	found := -1
+	if !last {
+		found = bytes.Index(rest, []byte("MEAS"))
+	} else if k := bytes.Index(rest, []byte(endMarker)); k >= 0 {
+		found = k + 2
+	}
+	if found < 0 {
+		found = len(rest)
+	}
+	f.anomaly(&ParseError{
+		Tag:    "MEAS",
+		Index:  m.Id,
+		Offset: m.Offset + 4,
+		Msg:    fmt.Sprintf("element data size %d, using %d", size, found),
+	})
+	return found
+}
+
+func (d *decoder) decode() (*Data, error) {
+	var err error
+	d.layout, err = DetectLayout(d.peek(4))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Data{Layout: d.layout, Charset: d.opts.Charset, mode: d.opts.Mode}
+	if err := d.readTaggedBlock(0, &f.Header); err != nil {
+		return nil, err
+	}
+	if err := f.checkVersion(); err != nil {
+		return nil, err
+	}
+	h := &f.Header
+	if err := h.checkCounts(d.remaining(), d.layout); err != nil {
+		return nil, err
+	}
+	for _, err := range []error{
+		d.checkLimit("staffs", int(h.StaffCount), d.opts.MaxStaffs),
+		d.checkLimit("pages", int(h.PageCount), d.opts.MaxPages),
+		d.checkLimit("lines", int(h.LineCount), d.opts.MaxLines),
+		d.checkLimit("measures", int(h.MeasureCount), d.opts.MaxMeasures),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.Staff = make([]*Staff, h.StaffCount)
+	for i := 0; i < len(f.Staff); i++ {
+		s := new(Staff)
+		f.Staff[i] = s
+		s.Id = i
+		s.charset = f.Charset
+		if err := d.readTaggedBlock(i, s); err != nil {
+			return nil, err
+		}
+	}
+
+	f.Pages = make([]*Page, h.PageCount)
+	for i := 0; i < int(h.PageCount); i++ {
+		p := new(Page)
+		p.Id = i
+		if err := d.readTaggedBlock(i, p); err != nil {
+			return nil, err
+		}
+		f.Pages[i] = p
+	}
+
+	f.Lines = make([]*Line, h.LineCount)
+	for i := 0; i < int(h.LineCount); i++ {
+		l := new(Line)
+		l.StaffMap = map[int]*LineStaffData{}
+		l.Id = i
+		f.Lines[i] = l
+		if err := d.readTaggedBlock(i, l); err != nil {
+			return nil, err
+		}
+		var err error
+		l.VarData, err = d.next(int(l.VarSize), "LINE", i)
+		if err != nil {
+			return nil, err
+		}
+		fillFields(l.VarData, &l.LineData)
+		if err := l.lineReadStaffs(); err != nil {
+			return nil, err
+		}
+		for j, s := range l.Staffs {
+			if s.Key <= MaxKey {
+				continue
+			}
+			if err := f.anomaly(&ParseError{
+				Tag:    "LINE",
+				Index:  i,
+				Offset: l.Offset + len(l.Raw) + 26 + 30*j + 2,
+				Msg:    fmt.Sprintf("staff %d: key %d out of range", j, s.Key),
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	measures := make([]*Measure, h.MeasureCount)
+	for i := 0; i < int(h.MeasureCount); i++ {
+		if d.opts.Mode == Lenient && !d.findMeasure(f, i) {
+			measures = measures[:i]
+			break
+		}
+		m := new(Measure)
+		m.Id = i
+		measures[i] = m
+		if err := d.readTaggedBlock(i, m); err != nil {
+			return nil, err
+		}
+		size := int(m.VarSize)
+		if d.opts.Mode == Lenient {
+			size = d.measureSize(f, m, len(measures))
+		}
+		var err error
+		m.VarData, err = d.next(size, "MEAS", i)
+		if err != nil {
+			return nil, err
+		}
+		if d.opts.LazyMeasures {
+			continue
+		}
+		if err := f.readElems(m); err != nil {
+			return nil, err
+		}
+	}
+	if d.opts.LazyMeasures {
+		f.measureIndex = measures
+		f.loaded = make([]bool, len(measures))
+	} else {
+		f.Measures = measures
+	}
+
+	off := d.off
+	rest, err := d.rest()
+	if err != nil {
+		return nil, err
+	}
+	f.Extra = readSections(rest, off)
+	f.readTexts()
+
+	if err := setLinks(f, measures); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,303 @@
+// Code generated by gen_decoders.go; DO NOT EDIT.
+
+package encore
+
+import "encoding/binary"
+
+func (v *Header) decodeFields(raw []byte) {
+	if len(raw) >= 5 {
+		v.FormatVersion = raw[4]
+	}
+	if len(raw) >= 48 {
+		v.LineCount = int16(binary.LittleEndian.Uint16(raw[46:]))
+	}
+	if len(raw) >= 50 {
+		v.PageCount = int16(binary.LittleEndian.Uint16(raw[48:]))
+	}
+	if len(raw) >= 51 {
+		v.StaffCount = raw[50]
+	}
+	if len(raw) >= 52 {
+		v.StaffPerSystem = raw[51]
+	}
+	if len(raw) >= 54 {
+		v.MeasureCount = int16(binary.LittleEndian.Uint16(raw[52:]))
+	}
+}
+
+func (v *Line) decodeFields(raw []byte) {
+	if len(raw) >= 8 {
+		v.VarSize = binary.LittleEndian.Uint32(raw[4:])
+	}
+	v.LineData.decodeFields(raw)
+}
+
+func (v *Page) decodeFields(raw []byte) {
+}
+
+func (v *LineStaffData) decodeFields(raw []byte) {
+	if len(raw) >= 2 {
+		v.Clef = raw[1]
+	}
+	if len(raw) >= 3 {
+		v.Key = raw[2]
+	}
+	if len(raw) >= 4 {
+		v.PageIdx = raw[3]
+	}
+	if len(raw) >= 8 {
+		v.StaffType = raw[7]
+	}
+	if len(raw) >= 9 {
+		v.StaffIdx = raw[8]
+	}
+}
+
+func (v *LineData) decodeFields(raw []byte) {
+	if len(raw) >= 12 {
+		v.Start = binary.LittleEndian.Uint16(raw[10:])
+	}
+	if len(raw) >= 13 {
+		v.MeasureCount = raw[12]
+	}
+}
+
+func (v *Measure) decodeFields(raw []byte) {
+	if len(raw) >= 8 {
+		v.VarSize = int32(binary.LittleEndian.Uint32(raw[4:]))
+	}
+	if len(raw) >= 10 {
+		v.Bpm = binary.LittleEndian.Uint16(raw[8:])
+	}
+	if len(raw) >= 11 {
+		v.TimeSigGlyph = raw[10]
+	}
+	if len(raw) >= 14 {
+		v.BeatTicks = binary.LittleEndian.Uint16(raw[12:])
+	}
+	if len(raw) >= 16 {
+		v.DurTicks = binary.LittleEndian.Uint16(raw[14:])
+	}
+	if len(raw) >= 17 {
+		v.TimeSigNum = raw[16]
+	}
+	if len(raw) >= 18 {
+		v.TimeSigDen = raw[17]
+	}
+	if len(raw) >= 21 {
+		v.BarTypeStart = raw[20]
+	}
+	if len(raw) >= 22 {
+		v.BarTypeEnd = raw[21]
+	}
+	if len(raw) >= 23 {
+		v.RepeatMarker = raw[22]
+	}
+	if len(raw) >= 24 {
+		v.RepeatAlternative = raw[23]
+	}
+	if len(raw) >= 37 {
+		v.Coda = binary.LittleEndian.Uint32(raw[33:])
+	}
+}
+
+func (v *Staff) decodeFields(raw []byte) {
+	if len(raw) >= 18 {
+		copy(v.Name[:], raw[8:])
+	}
+	if len(raw) >= 166 {
+		v.Transposition = int8(raw[165])
+	}
+	if len(raw) >= 173 {
+		v.Clef = raw[172]
+	}
+	if len(raw) >= 182 {
+		v.PianoStaff = raw[181]
+	}
+	if len(raw) >= 196 {
+		copy(v.MidiChannel[:], raw[188:])
+	}
+	if len(raw) >= 204 {
+		copy(v.MidiProgram[:], raw[196:])
+	}
+	if len(raw) >= 212 {
+		copy(v.MidiVolume[:], raw[204:])
+	}
+}
+
+func (v *NoDuration) decodeFields(raw []byte) {
+}
+
+func (v *MeasElem) decodeFields(raw []byte) {
+	if len(raw) >= 2 {
+		v.Tick = binary.LittleEndian.Uint16(raw[0:])
+	}
+	if len(raw) >= 3 {
+		v.TypeVoice = raw[2]
+	}
+	if len(raw) >= 4 {
+		v.Size = raw[3]
+	}
+	if len(raw) >= 5 {
+		v.StaffIdx = raw[4]
+	}
+}
+
+func (v *Note) decodeFields(raw []byte) {
+	v.WithDuration.decodeFields(raw)
+	if len(raw) >= 7 {
+		v.Grace = raw[6]
+	}
+	if len(raw) >= 11 {
+		v.XOffset = raw[10]
+	}
+	if len(raw) >= 13 {
+		v.Position = int8(raw[12])
+	}
+	if len(raw) >= 16 {
+		v.SemitonePitch = raw[15]
+	}
+	if len(raw) >= 18 {
+		v.PlaybackDurationTicks = binary.LittleEndian.Uint16(raw[16:])
+	}
+	if len(raw) >= 20 {
+		v.Velocity = raw[19]
+	}
+	if len(raw) >= 21 {
+		v.Options = raw[20]
+	}
+	if len(raw) >= 22 {
+		v.AlterationGlyph = raw[21]
+	}
+	if len(raw) >= 25 {
+		v.ArticulationUp = raw[24]
+	}
+	if len(raw) >= 27 {
+		v.ArticulationDown = raw[26]
+	}
+}
+
+func (v *Slur) decodeFields(raw []byte) {
+	v.NoDuration.decodeFields(raw)
+	if len(raw) >= 6 {
+		v.SlurType = raw[5]
+	}
+	if len(raw) >= 11 {
+		v.LeftX = raw[10]
+	}
+	if len(raw) >= 13 {
+		v.LeftPosition = raw[12]
+	}
+	if len(raw) >= 15 {
+		v.MiddleX = raw[14]
+	}
+	if len(raw) >= 17 {
+		v.MiddlePosition = raw[16]
+	}
+	if len(raw) >= 19 {
+		v.MeasureDelta = raw[18]
+	}
+	if len(raw) >= 21 {
+		v.RightX = raw[20]
+	}
+	if len(raw) >= 23 {
+		v.RightPosition = raw[22]
+	}
+}
+
+func (v *KeyChange) decodeFields(raw []byte) {
+	v.NoDuration.decodeFields(raw)
+	if len(raw) >= 6 {
+		v.NewKey = raw[5]
+	}
+	if len(raw) >= 11 {
+		v.OldKey = raw[10]
+	}
+}
+
+func (v *Other) decodeFields(raw []byte) {
+	v.NoDuration.decodeFields(raw)
+}
+
+func (v *Script) decodeFields(raw []byte) {
+	v.NoDuration.decodeFields(raw)
+	if len(raw) >= 11 {
+		v.XOff = raw[10]
+	}
+}
+
+func (v *Clef) decodeFields(raw []byte) {
+	v.NoDuration.decodeFields(raw)
+	if len(raw) >= 6 {
+		v.ClefType = raw[5]
+	}
+	if len(raw) >= 11 {
+		v.XOff = raw[10]
+	}
+}
+
+func (v *SubBeam) decodeFields(raw []byte) {
+	if len(raw) >= 1 {
+		v.StartX = raw[0]
+	}
+	if len(raw) >= 3 {
+		v.EndX = raw[2]
+	}
+}
+
+func (v *Beam) decodeFields(raw []byte) {
+	v.NoDuration.decodeFields(raw)
+	if len(raw) >= 19 {
+		v.LeftPos = int8(raw[18])
+	}
+	if len(raw) >= 20 {
+		v.RightPos = int8(raw[19])
+	}
+	if len(raw) >= 22 {
+		v.EndNoteTick = binary.LittleEndian.Uint16(raw[20:])
+	}
+	if len(raw) >= 24 {
+		v.TupletNumber = raw[23]
+	}
+}
+
+func (v *WithDuration) decodeFields(raw []byte) {
+	if len(raw) >= 6 {
+		v.FaceValue = raw[5]
+	}
+	if len(raw) >= 14 {
+		v.Tuplet = raw[13]
+	}
+	if len(raw) >= 15 {
+		v.DotControl = raw[14]
+	}
+	if len(raw) >= 18 {
+		v.PlaybackDurationTicks = binary.LittleEndian.Uint16(raw[16:])
+	}
+}
+
+func (v *Rest) decodeFields(raw []byte) {
+	v.WithDuration.decodeFields(raw)
+	if len(raw) >= 11 {
+		v.XOffset = raw[10]
+	}
+	if len(raw) >= 13 {
+		v.Position = int8(raw[12])
+	}
+}
+
+func (v *Tie) decodeFields(raw []byte) {
+	v.NoDuration.decodeFields(raw)
+	if len(raw) >= 6 {
+		v.LeftDurationType = raw[5]
+	}
+	if len(raw) >= 11 {
+		v.XOffset = raw[10]
+	}
+	if len(raw) >= 13 {
+		v.NotePosition = raw[12]
+	}
+	if len(raw) >= 15 {
+		v.TiePosition = raw[14]
+	}
+}
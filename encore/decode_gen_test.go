@@ -0,0 +1,84 @@
+package encore
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// decodeTypes returns a fresh value of every type that is decoded
+// with fillFields.
+func decodeTypes() []interface{} {
+	return []interface{}{
+		&Header{}, &Line{}, &Page{}, &LineStaffData{}, &LineData{},
+		&Measure{}, &Staff{}, &MeasElem{}, &Note{}, &Slur{},
+		&KeyChange{}, &Other{}, &Script{}, &Clef{}, &SubBeam{},
+		&Beam{}, &WithDuration{}, &Rest{}, &Tie{},
+	}
+}
+
+func TestGeneratedDecoders(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i, v := range decodeTypes() {
+		if _, ok := v.(fieldDecoder); !ok {
+			t.Errorf("%T has no generated decoder; run go generate", v)
+			continue
+		}
+		for _, n := range []int{0, 1, 5, 9, 17, 30, 62, 194, 242} {
+			raw := make([]byte, n)
+			rnd.Read(raw)
+			want := decodeTypes()[i]
+			got := decodeTypes()[i]
+			reflectFillFields(raw, want)
+			got.(fieldDecoder).decodeFields(raw)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%d bytes: got %+v, want %+v", n, got, want)
+			}
+		}
+	}
+}
+
+// benchFile returns a file with the given number of measures, each
+// holding 100 notes.
+func benchFile(measures int) []byte {
+	d, _ := ReadData(testFile())
+	m := d.Measures[0]
+	for len(m.Elems) < 100 {
+		m.Elems = append(m.Elems, m.Elems[0])
+	}
+	for len(d.Measures) < measures {
+		d.Measures = append(d.Measures, m)
+	}
+
+	var buf bytes.Buffer
+	NewEncoder(&buf).Encode(d)
+	return buf.Bytes()
+}
+
+func BenchmarkReadData(b *testing.B) {
+	c := benchFile(400)
+	b.SetBytes(int64(len(c)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadData(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFillFieldsReflect(b *testing.B) {
+	raw := testFile()[len(testFile())-30:]
+	for i := 0; i < b.N; i++ {
+		var n Note
+		reflectFillFields(raw, &n)
+	}
+}
+
+func BenchmarkFillFieldsGenerated(b *testing.B) {
+	raw := testFile()[len(testFile())-30:]
+	for i := 0; i < b.N; i++ {
+		var n Note
+		fillFields(raw, &n)
+	}
+}
@@ -0,0 +1,80 @@
+package encore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecode(t *testing.T) {
+	c := testFile()
+	d, err := Decode(iotest.OneByteReader(bytes.NewReader(c)), nil)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if d.Raw != nil {
+		t.Errorf("Raw retained without KeepRaw")
+	}
+	want, _ := ReadData(c)
+	if got, want := d.Measures[0].Elems[0].Raw, want.Measures[0].Elems[0].Raw; !bytes.Equal(got, want) {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	d, err = Decode(bytes.NewReader(c), &DecodeOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !bytes.Equal(d.Raw, c) {
+		t.Errorf("Raw not retained with KeepRaw")
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	c := testFile()
+	for _, opts := range []*DecodeOptions{
+		{MaxSize: int64(len(c) - 1)},
+		{MaxSize: int64(len(c) - 1), KeepRaw: true},
+	} {
+		if _, err := Decode(bytes.NewReader(c), opts); !errors.Is(err, ErrLimit) {
+			t.Errorf("Decode(%+v): got %v, want ErrLimit", opts, err)
+		}
+	}
+	if _, err := Decode(bytes.NewReader(c), &DecodeOptions{MaxSize: int64(len(c))}); err != nil {
+		t.Errorf("Decode: %v", err)
+	}
+
+	// Claim 2 measures; the limit must trigger before reading them.
+	c[0x34] = 2
+	if _, err := Decode(bytes.NewReader(c), &DecodeOptions{MaxMeasures: 1}); !errors.Is(err, ErrLimit) {
+		t.Errorf("got %v, want ErrLimit", err)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	c := testFile()
+	_, err := Decode(bytes.NewReader(c[:len(c)-1]), nil)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDecodeHugeSize(t *testing.T) {
+	// A LINE block claiming 3.75 GB of variable data.
+	c := testFile()
+	binary.LittleEndian.PutUint32(c[194+242+34+4:], 0xf0000000)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Decode(bytes.NewReader(c), nil)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes for a %d byte file", n, len(c))
+	}
+}
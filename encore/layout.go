@@ -0,0 +1,205 @@
+package encore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Field describes a struct field decoded from a fixed offset.
+type Field struct {
+	Name   string
+	Offset int
+	Size   int
+}
+
+// Fields returns the fields with an offset tag of the struct pointed
+// to by v, including those of embedded structs, sorted by offset.
+func Fields(v interface{}) []Field {
+	var fields []Field
+	seen := map[Field]bool{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.Anonymous {
+				walk(sf.Type)
+				continue
+			}
+			offStr := sf.Tag.Get("offset")
+			if offStr == "" {
+				continue
+			}
+			off, _ := strconv.ParseInt(offStr, 0, 64)
+			f := Field{
+				Name:   sf.Name,
+				Offset: int(off),
+				Size:   binary.Size(reflect.Zero(sf.Type).Interface()),
+			}
+			if !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+	walk(reflect.TypeOf(v).Elem())
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].Offset < fields[j].Offset
+	})
+	return fields
+}
+
+// FieldAt returns the field covering byte off, or nil.
+func FieldAt(fields []Field, off int) *Field {
+	for i := range fields {
+		f := &fields[i]
+		if f.Offset <= off && off < f.Offset+f.Size {
+			return f
+		}
+	}
+	return nil
+}
+
+// Location identifies the part of a file a byte offset belongs to.
+type Location struct {
+	// Block tag, eg. "SCOW" or "MEAS". Empty for untagged
+	// sections and offsets past the end.
+	Block string
+
+	// Index of the block, and of the element or line staff within
+	// it, or -1.
+	Index int
+	Elem  int
+
+	// Name of the struct type covering the byte, eg. "Note".
+	Struct string
+
+	// Byte offset relative to the start of the struct.
+	Rel int
+
+	// Name of the field covering the byte, or empty if unmapped.
+	Field string
+}
+
+func (l *Location) String() string {
+	s := l.Block
+	if s == "" {
+		s = "untagged"
+	}
+	if l.Index >= 0 {
+		s += fmt.Sprintf(" %d", l.Index)
+	}
+	if l.Elem >= 0 {
+		s += fmt.Sprintf(" elem %d", l.Elem)
+	}
+	s += fmt.Sprintf(" %s byte %d", l.Struct, l.Rel)
+	if l.Field != "" {
+		s += " (" + l.Field + ")"
+	} else {
+		s += " (unmapped)"
+	}
+	return s
+}
+
+// locateIn fills in Struct, Rel and Field for the struct v starting
+// at start.
+func (l *Location) locateIn(v interface{}, start, off int) *Location {
+	l.Struct = reflect.TypeOf(v).Elem().Name()
+	l.Rel = off - start
+	if f := FieldAt(Fields(v), l.Rel); f != nil {
+		l.Field = f.Name
+	}
+	return l
+}
+
+func blockTag(raw []byte, dflt string) string {
+	if len(raw) >= 4 {
+		return string(raw[:4])
+	}
+	return dflt
+}
+
+// Locate returns the location of byte off of the file d was decoded
+// from.
+func (d *Data) Locate(off int) *Location {
+	in := func(start int, raw []byte) bool {
+		return start <= off && off < start+len(raw)
+	}
+	h := &d.Header
+	if in(h.Offset, h.Raw) {
+		return (&Location{Block: blockTag(h.Raw, "SCOW"), Index: -1, Elem: -1}).locateIn(h, h.Offset, off)
+	}
+	for _, s := range d.Staff {
+		if in(s.Offset, s.Raw) {
+			return (&Location{Block: blockTag(s.Raw, "TK00"), Index: s.Id, Elem: -1}).locateIn(s, s.Offset, off)
+		}
+	}
+	for _, p := range d.Pages {
+		if in(p.Offset, p.Raw) {
+			return (&Location{Block: "PAGE", Index: p.Id, Elem: -1}).locateIn(p, p.Offset, off)
+		}
+	}
+	for _, l := range d.Lines {
+		loc := &Location{Block: "LINE", Index: l.Id, Elem: -1}
+		if in(l.Offset, l.Raw) {
+			return loc.locateIn(l, l.Offset, off)
+		}
+		varOff := l.Offset + len(l.Raw)
+		if !in(varOff, l.VarData) {
+			continue
+		}
+		if off < varOff+26 {
+			return loc.locateIn(&l.LineData, varOff, off)
+		}
+		for j, s := range l.Staffs {
+			start := varOff + 26 + 30*j
+			if in(start, s.Raw) {
+				loc.Elem = j
+				return loc.locateIn(s, start, off)
+			}
+		}
+	}
+	for _, m := range d.Measures {
+		loc := &Location{Block: "MEAS", Index: m.Id, Elem: -1}
+		if in(m.Offset, m.Raw) {
+			return loc.locateIn(m, m.Offset, off)
+		}
+		if !in(m.Offset+len(m.Raw), m.VarData) {
+			continue
+		}
+		for j, e := range m.Elems {
+			if !in(e.Offset, e.Raw) {
+				continue
+			}
+			loc.Elem = j
+			loc.locateIn(e, e.Offset, off)
+			if loc.Field != "" || e.TypeSpecific == nil {
+				return loc
+			}
+			loc.locateIn(e.TypeSpecific, e.Offset, off)
+			if b, ok := e.TypeSpecific.(*Beam); ok && loc.Field == "" && loc.Rel >= 14 {
+				i := (loc.Rel - 14) / 16
+				if i < len(b.SubBeams) {
+					loc.locateIn(&b.SubBeams[i], e.Offset+14+16*i, off)
+					loc.Struct = fmt.Sprintf("SubBeam[%d]", i)
+				}
+			}
+			return loc
+		}
+		start := m.Offset + len(m.Raw)
+		if n := len(m.Elems); n > 0 {
+			start = m.Elems[n-1].Offset + len(m.Elems[n-1].Raw)
+		}
+		loc.Struct = "end marker"
+		loc.Rel = off - start
+		return loc
+	}
+	for i, s := range d.Extra {
+		if in(s.Offset, s.Raw) {
+			return &Location{Block: s.Tag, Index: i, Elem: -1, Struct: "Section", Rel: off - s.Offset}
+		}
+	}
+	return &Location{Index: -1, Elem: -1, Struct: "end of file", Rel: off}
+}
@@ -0,0 +1,69 @@
+package encore
+
+import (
+	"fmt"
+)
+
+// NumMeasures returns the number of measures.
+func (d *Data) NumMeasures() int {
+	if d.measureIndex != nil {
+		return len(d.measureIndex)
+	}
+	return len(d.Measures)
+}
+
+// Measure returns measure i, decoding its elements if the data was
+// decoded with LazyMeasures.
+func (d *Data) Measure(i int) (*Measure, error) {
+	if i < 0 || i >= d.NumMeasures() {
+		return nil, fmt.Errorf("measure %d out of range [0, %d)", i, d.NumMeasures())
+	}
+	if d.measureIndex == nil {
+		return d.Measures[i], nil
+	}
+
+	m := d.measureIndex[i]
+	if !d.loaded[i] {
+		err := d.readElems(m)
+		if err == nil {
+			err = d.linkElems(m)
+		}
+		if err != nil {
+			m.Elems = nil
+			return nil, err
+		}
+		d.loaded[i] = true
+	}
+	return m, nil
+}
+
+// EachMeasure calls fn for measures start up to end, stopping at
+// the first error.
+func (d *Data) EachMeasure(start, end int, fn func(m *Measure) error) error {
+	for i := start; i < end; i++ {
+		m, err := d.Measure(i)
+		if err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadMeasures decodes all measures into Measures.
+func (d *Data) LoadMeasures() error {
+	if d.measureIndex == nil {
+		return nil
+	}
+	for i := range d.measureIndex {
+		if _, err := d.Measure(i); err != nil {
+			return err
+		}
+	}
+	d.Measures = d.measureIndex
+	d.measureIndex = nil
+	d.loaded = nil
+	return nil
+}
@@ -0,0 +1,54 @@
+package encore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLazyMeasures(t *testing.T) {
+	c := benchFile(5)
+	eager, err := ReadData(c)
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	d, err := Decode(bytes.NewReader(c), &DecodeOptions{LazyMeasures: true})
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if d.Measures != nil {
+		t.Fatalf("Measures decoded eagerly")
+	}
+	if got := d.NumMeasures(); got != 5 {
+		t.Fatalf("got %d measures, want 5", got)
+	}
+
+	var got []*Measure
+	if err := d.EachMeasure(2, 4, func(m *Measure) error {
+		got = append(got, m)
+		return nil
+	}); err != nil {
+		t.Fatalf("EachMeasure: %v", err)
+	}
+	if d.loaded[0] || !d.loaded[2] || !d.loaded[3] || d.loaded[4] {
+		t.Errorf("got loaded %v, want measures 2 and 3", d.loaded)
+	}
+	for i, m := range got {
+		want := eager.Measures[2+i]
+		if m.AbsTick != want.AbsTick || len(m.Elems) != len(want.Elems) {
+			t.Errorf("measure %d: got tick %d, %d elems, want %d, %d",
+				2+i, m.AbsTick, len(m.Elems), want.AbsTick, len(want.Elems))
+		}
+		e := m.Elems[0]
+		if e.Measure != m || e.LineStaffData == nil || e.AbsTick() != want.Elems[0].AbsTick() {
+			t.Errorf("measure %d: element not linked: %+v", 2+i, e)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(d); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), c) {
+		t.Errorf("Encode of lazy data differs")
+	}
+}
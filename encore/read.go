@@ -4,31 +4,78 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
-func (l *Line) lineReadStaffs() {
+// ParseError is returned by ReadData if the input is malformed.
+type ParseError struct {
+	// Tag of the block being parsed, eg. "SCOW", "LINE" or "MEAS".
+	Tag string
+
+	// Index of the block among blocks with the same tag.
+	Index int
+
+	// Byte offset in the file where parsing failed.
+	Offset int
+
+	Msg string
+
+	// Err is the underlying error, eg. io.ErrUnexpectedEOF or
+	// ErrLimit, if any.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Msg
+	if e.Err != nil {
+		if msg != "" {
+			msg += ": "
+		}
+		msg += e.Err.Error()
+	}
+	return fmt.Sprintf("%s %d at offset %d: %s", e.Tag, e.Index, e.Offset, msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (l *Line) lineReadStaffs() error {
+	if len(l.VarData) < 26 {
+		return &ParseError{
+			Tag:    "LINE",
+			Index:  l.Id,
+			Offset: l.Offset + len(l.Raw),
+			Msg:    fmt.Sprintf("line data too short: %d", len(l.VarData)),
+		}
+	}
 	d := l.VarData[26:]
 	if len(d)%30 != 0 {
-		log.Fatalf("must be multiple of 30: %d", len(d))
+		return &ParseError{
+			Tag:    "LINE",
+			Index:  l.Id,
+			Offset: l.Offset + len(l.Raw) + 26,
+			Msg:    fmt.Sprintf("staff data must be multiple of 30: %d", len(d)),
+		}
 	}
 	for len(d) > 0 {
 		staffRaw := d[:30]
 		d = d[30:]
-		lsd := &LineStaffData{}
+		lsd := &LineStaffData{Raw: staffRaw}
 		fillFields(staffRaw, lsd)
 		l.Staffs = append(l.Staffs, lsd)
 	}
 	for _, s := range l.Staffs {
 		l.StaffMap[int(s.StaffIdx)] = s
 	}
+	return nil
 }
 
 func readElem(c []byte, off int) (result *MeasElem) {
-	result = new(MeasElem)
-	fillBlock(c, off, result)
+	result = &MeasElem{Raw: c, Offset: off}
+	fillFields(c, result)
 
 	var e MeasElemSpecific
 	switch result.Type() {
@@ -48,6 +95,8 @@ func readElem(c []byte, off int) (result *MeasElem) {
 			e = &Other{}
 		case 28:
 			e = &Slur{}
+		default:
+			e = &Other{}
 		}
 	case TYPE_REST:
 		e = &Rest{}
@@ -61,7 +110,9 @@ func readElem(c []byte, off int) (result *MeasElem) {
 	fillFields(c, e)
 	if result.Type() == TYPE_BEAM {
 		b := e.(*Beam)
-		b.SubBeams = make([]SubBeam, (result.Size-14)/16)
+		if len(c) > 14 {
+			b.SubBeams = make([]SubBeam, (len(c)-14)/16)
+		}
 		for i := range b.SubBeams {
 			fillFields(result.Raw[14+16*i:], &b.SubBeams[i])
 		}
@@ -71,26 +122,108 @@ func readElem(c []byte, off int) (result *MeasElem) {
 
 var endMarker = string([]byte{255, 255})
 
-func (m *Measure) readElems() {
+// plausibleElem returns whether an element of a measure with the
+// given duration could start at r[p:].
+func plausibleElem(r []byte, p int, dur uint16) bool {
+	if len(r)-p < 4 {
+		return false
+	}
+	typ, sz := int(r[p+2]>>4), int(r[p+3])
+	tick := binary.LittleEndian.Uint16(r[p:])
+	return typ >= TYPE_CLEF && typ <= TYPE_NOTE && sz >= 4 && p+sz <= len(r) && tick <= dur
+}
+
+// resync returns the number of bytes to skip in r to get to the next
+// plausible element that is followed by another one or by the end
+// marker.
+func (m *Measure) resync(r []byte) int {
+	for p := 1; p < len(r); p++ {
+		if strings.HasPrefix(string(r[p:]), endMarker) {
+			return p
+		}
+		if !plausibleElem(r, p, m.DurTicks) {
+			continue
+		}
+		next := p + int(r[p+3])
+		if next == len(r) || strings.HasPrefix(string(r[next:]), endMarker) ||
+			plausibleElem(r, next, m.DurTicks) {
+			return p
+		}
+	}
+	return len(r)
+}
+
+// anomaly records pe, or returns it in Strict mode.
+func (d *Data) anomaly(pe *ParseError) error {
+	if d.mode == Strict {
+		return pe
+	}
+	d.Anomalies = append(d.Anomalies, pe)
+	return nil
+}
+
+func (d *Data) readElems(m *Measure) error {
 	r := m.VarData
 	off := m.Offset + 62 // todo - extract.
-	for len(r) >= 3 {
+	for len(r) >= 4 {
 		if string(r[:2]) == endMarker {
 			break
 		}
 		sz := int(r[3])
-		if sz < 3 {
-			log.Fatalf("got sz %d: %q, left %d bytes", sz, r[:10], len(r))
+		if sz < 4 || sz > len(r) {
+			pe := &ParseError{
+				Tag:    "MEAS",
+				Index:  m.Id,
+				Offset: off,
+				Msg:    fmt.Sprintf("element size %d, left %d bytes", sz, len(r)),
+			}
+			if d.mode != Lenient {
+				return pe
+			}
+			skip := m.resync(r)
+			pe.Msg += fmt.Sprintf("; skipped %d bytes", skip)
+			d.anomaly(pe)
+			r = r[skip:]
+			off += skip
+			continue
 		}
 
-		m.Elems = append(m.Elems, readElem(r[:sz], off))
+		e := readElem(r[:sz], off)
 		r = r[sz:]
 		off += sz
+		msg := e.Problem()
+		if t := e.Type(); t < TYPE_CLEF || t > TYPE_NOTE {
+			msg = fmt.Sprintf("unknown element type %d", t)
+		}
+		if msg != "" {
+			pe := &ParseError{
+				Tag:    "MEAS",
+				Index:  m.Id,
+				Offset: e.Offset,
+				Msg:    msg,
+			}
+			if d.mode == Lenient {
+				pe.Msg += "; dropped"
+			}
+			if err := d.anomaly(pe); err != nil {
+				return err
+			}
+			if d.mode == Lenient {
+				continue
+			}
+		}
+		m.Elems = append(m.Elems, e)
 	}
 
 	if string(r) != endMarker {
-		log.Printf("end marker not found: have %q", r)
+		return d.anomaly(&ParseError{
+			Tag:    "MEAS",
+			Index:  m.Id,
+			Offset: off,
+			Msg:    fmt.Sprintf("end marker not found: have %q", r),
+		})
 	}
+	return nil
 }
 
 // Like fillFields, but fill Raw/Offset too.
@@ -105,11 +238,30 @@ func fillBlock(raw []byte, offset int, dest interface{}) {
 	fillFields(raw, dest)
 }
 
+// fieldDecoder is implemented by the generated decoders in
+// decode_gen.go.
+type fieldDecoder interface {
+	decodeFields(raw []byte)
+}
+
+// fillFields decodes the fields with an offset tag from raw. Fields
+// that do not fit in raw are left alone.
 func fillFields(raw []byte, dest interface{}) {
+	if fd, ok := dest.(fieldDecoder); ok {
+		fd.decodeFields(raw)
+		return
+	}
+	reflectFillFields(raw, dest)
+}
+
+// reflectFillFields is fillFields using reflection. It is used for
+// types that have no generated decoder; run "go generate" after
+// changing the structs in data.go.
+func reflectFillFields(raw []byte, dest interface{}) {
 	v := reflect.ValueOf(dest).Elem()
 	for i := 0; i < v.NumField(); i++ {
 		if v.Type().Field(i).Anonymous {
-			fillFields(raw, v.Field(i).Addr().Interface())
+			reflectFillFields(raw, v.Field(i).Addr().Interface())
 			continue
 		}
 		f := v.Field(i)
@@ -128,101 +280,161 @@ func fillFields(raw []byte, dest interface{}) {
 	}
 }
 
-func readTaggedBlock(c []byte, off int, dest interface{}) int {
-	v := reflect.ValueOf(dest).Elem()
-
-	tagField, ok := v.Type().FieldByName("Raw")
-	if !ok {
-		log.Fatalf("missing Raw in %T", dest)
+// readTaggedBlock reads the fixed size part of block number idx into
+// dest.
+func (d *decoder) readTaggedBlock(idx int, dest interface{}) error {
+	bl := d.layout.block(dest)
+	want := ""
+	if len(bl.Tags) > 0 {
+		want = bl.Tags[0]
+	}
+	off := d.off
+	raw, err := d.next(bl.Size, want, idx)
+	if err != nil {
+		return err
 	}
 
-	fixed := tagField.Tag.Get("fixed")
-	sz, _ := strconv.ParseInt(fixed, 0, 64)
-	rawAddr := v.FieldByName("Raw").Addr().Interface().(*[]byte)
-	raw := c[off : off+int(sz)]
-	*rawAddr = raw
-	
-	want := tagField.Tag.Get("want")
-	if want != "" && string(raw[:len(want)]) != want {
-		log.Fatalf("Got tag %q want %q - %q", raw[:len(want)], want, raw)
+	if len(bl.Tags) > 0 {
+		ok := false
+		for _, t := range bl.Tags {
+			ok = ok || strings.HasPrefix(string(raw), t)
+		}
+		if !ok {
+			return &ParseError{
+				Tag:    want,
+				Index:  idx,
+				Offset: off,
+				Msg:    fmt.Sprintf("got tag %q, want %s", raw[:len(want)], strings.Join(bl.Tags, " or ")),
+			}
+		}
 	}
 	fillBlock(raw, off, dest)
+	return nil
+}
+
+// fixedSize returns the size of the fixed part of a block, as given
+// by the "fixed" tag on its Raw field.
+func fixedSize(dest interface{}) int {
+	f, _ := reflect.TypeOf(dest).Elem().FieldByName("Raw")
+	sz, _ := strconv.ParseInt(f.Tag.Get("fixed"), 0, 64)
 	return int(sz)
 }
 
+// checkCounts verifies that the blocks announced in the header fit
+// in the remaining n bytes.
+func (h *Header) checkCounts(n int, l *Layout) error {
+	if h.LineCount < 0 || h.PageCount < 0 || h.MeasureCount < 0 {
+		return &ParseError{
+			Tag:    "SCOW",
+			Offset: h.Offset,
+			Msg:    fmt.Sprintf("negative count: %v", h),
+		}
+	}
+	need := int(h.StaffCount)*l.Staff.Size +
+		int(h.PageCount)*l.Page.Size +
+		int(h.LineCount)*l.Line.Size +
+		int(h.MeasureCount)*l.Measure.Size
+	if need > n {
+		return &ParseError{
+			Tag:    "SCOW",
+			Offset: h.Offset,
+			Msg:    fmt.Sprintf("counts need %d bytes, have %d: %v", need, n, h),
+		}
+	}
+	return nil
+}
+
 func (h *Header) String() string {
 	return fmt.Sprintf("Systems %d PAGE %d Staff %d staffpersys %d MEAS %d",
 		h.LineCount, h.PageCount, h.StaffCount, h.StaffPerSystem,
 		h.MeasureCount)
 }
 
+// ReadData parses the contents of an Encore file. Malformed input
+// results in a *ParseError.
 func ReadData(c []byte) (*Data, error) {
-	f := new(Data)
+	return ReadDataOptions(c, nil)
+}
+
+// ReadDataOptions is ReadData with options, as for Decode. The
+// complete input is kept in Data.Raw regardless of KeepRaw.
+func ReadDataOptions(c []byte, opts *DecodeOptions) (*Data, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+	if opts.MaxSize > 0 && int64(len(c)) > opts.MaxSize {
+		return nil, &ParseError{
+			Offset: int(opts.MaxSize),
+			Msg:    fmt.Sprintf("input larger than %d bytes", opts.MaxSize),
+			Err:    ErrLimit,
+		}
+	}
+	d := &decoder{buf: c, opts: *opts}
+	f, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
 	f.Raw = c
-	off := 0
-	off += readTaggedBlock(c, off, &f.Header)
-	f.Staff = make([]*Staff, f.Header.StaffCount)
-	for i := 0; i < len(f.Staff); i++ {
-		s := new(Staff)
-		f.Staff[i] = s
-		s.Id = i
-		off += readTaggedBlock(c, off, s)
-	}
-
-	f.Pages = make([]*Page, f.Header.PageCount)
-	for i := 0; i < int(f.Header.PageCount); i++ {
-		p := new(Page)
-		p.Id = i
-		off += readTaggedBlock(c, off, p)
-		f.Pages[i] = p
-	}
-
-	f.Lines = make([]*Line, f.Header.LineCount)
-	for i := 0; i < int(f.Header.LineCount); i++ {
-		l := new(Line)
-		l.StaffMap = map[int]*LineStaffData{}
-		l.Id = i
-		f.Lines[i] = l
-		off += readTaggedBlock(c, off, l)
-		l.VarData = c[off : off+int(l.VarSize)]
-		off += int(l.VarSize)
-		fillFields(l.VarData, &l.LineData)
-		l.lineReadStaffs()
-	}
-
-	f.Measures = make([]*Measure, f.Header.MeasureCount)
-	for i := 0; i < int(f.Header.MeasureCount); i++ {
-		m := new(Measure)
-		m.Id = i
-		f.Measures[i] = m
-		off += readTaggedBlock(c, off, m)
-		m.VarData = c[off : off+int(m.VarSize)]
-		off += int(m.VarSize)
-		m.readElems()
-	}
-
-	setLinks(f)
 	return f, nil
 }
 
-func setLinks(d *Data) {
-	systemIdx := 0
+// setLinks links line staff data to their lines, computes AbsTick
+// for all measures and links the elements of decoded measures.
+func setLinks(d *Data, measures []*Measure) error {
 	for _, l := range d.Lines {
 		for _, s := range l.Staffs {
 			s.Line = l
 		}
 	}
 	var abs int
-	for i, m := range d.Measures {
-		for int(d.Lines[systemIdx].LineData.Start)+int(d.Lines[systemIdx].LineData.MeasureCount) < i {
-			systemIdx++
-		}
-		for _, e := range m.Elems {
-			e.Measure = m
-			e.Staff = d.Staff[e.GetStaff()]
-			e.LineStaffData = d.Lines[systemIdx].StaffMap[int(e.StaffIdx)]
+	for _, m := range measures {
+		if err := d.linkElems(m); err != nil {
+			return err
 		}
 		m.AbsTick = abs
 		abs += int(m.DurTicks)
 	}
+	return nil
+}
+
+// lineFor returns the line holding measure i, or nil.
+func (d *Data) lineFor(i int) *Line {
+	systemIdx := 0
+	for systemIdx+1 < len(d.Lines) &&
+		int(d.Lines[systemIdx].LineData.Start)+int(d.Lines[systemIdx].LineData.MeasureCount) < i {
+		systemIdx++
+	}
+	if systemIdx < len(d.Lines) {
+		return d.Lines[systemIdx]
+	}
+	return nil
+}
+
+func (d *Data) linkElems(m *Measure) error {
+	line := d.lineFor(m.Id)
+	elems := m.Elems[:0]
+	for _, e := range m.Elems {
+		if e.GetStaff() >= len(d.Staff) {
+			pe := &ParseError{
+				Tag:    "MEAS",
+				Index:  m.Id,
+				Offset: e.Offset,
+				Msg:    fmt.Sprintf("staff %d out of range", e.GetStaff()),
+			}
+			if d.mode != Lenient {
+				return pe
+			}
+			pe.Msg += "; dropped"
+			d.anomaly(pe)
+			continue
+		}
+		e.Measure = m
+		e.Staff = d.Staff[e.GetStaff()]
+		if line != nil {
+			e.LineStaffData = line.StaffMap[int(e.StaffIdx)]
+		}
+		elems = append(elems, e)
+	}
+	m.Elems = elems
+	return nil
 }
@@ -0,0 +1,226 @@
+package encore
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testFile returns a small synthetic Encore file with one staff, one
+// page, one line and one measure holding a single quarter note.
+func testFile() []byte {
+	var c []byte
+
+	head := make([]byte, 194)
+	copy(head, "SCOW")
+	binary.LittleEndian.PutUint16(head[0x2e:], 1) // lines
+	binary.LittleEndian.PutUint16(head[0x30:], 1) // pages
+	head[0x32] = 1                                // staffs
+	head[0x33] = 1
+	binary.LittleEndian.PutUint16(head[0x34:], 1) // measures
+	c = append(c, head...)
+
+	staff := make([]byte, 242)
+	copy(staff, "TK00")
+	copy(staff[8:], "Flute")
+	c = append(c, staff...)
+
+	page := make([]byte, 34)
+	copy(page, "PAGE")
+	c = append(c, page...)
+
+	line := make([]byte, 8)
+	copy(line, "LINE")
+	lineVar := make([]byte, 26+30)
+	lineVar[12] = 1
+	binary.LittleEndian.PutUint32(line[4:], uint32(len(lineVar)))
+	c = append(c, line...)
+	c = append(c, lineVar...)
+
+	note := make([]byte, 28)
+	note[2] = TYPE_NOTE << 4
+	note[3] = byte(len(note))
+	note[5] = 3
+	note[15] = 60
+	elems := append(note, 0xff, 0xff)
+
+	meas := make([]byte, 62)
+	copy(meas, "MEAS")
+	binary.LittleEndian.PutUint32(meas[4:], uint32(len(elems)))
+	binary.LittleEndian.PutUint16(meas[14:], 960)
+	meas[16] = 4
+	meas[17] = 4
+	c = append(c, meas...)
+	c = append(c, elems...)
+	return c
+}
+
+func TestReadData(t *testing.T) {
+	d, err := ReadData(testFile())
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	if len(d.Measures) != 1 || len(d.Measures[0].Elems) != 1 {
+		t.Fatalf("got %d measures, want 1 with 1 element", len(d.Measures))
+	}
+	n, ok := d.Measures[0].Elems[0].TypeSpecific.(*Note)
+	if !ok {
+		t.Fatalf("got %T, want *Note", d.Measures[0].Elems[0].TypeSpecific)
+	}
+	if n.SemitonePitch != 60 {
+		t.Errorf("got pitch %d, want 60", n.SemitonePitch)
+	}
+}
+
+func TestReadDataParseError(t *testing.T) {
+	c := testFile()
+	measOff := len(c) - 62 - 30
+	c[measOff] = 'X'
+
+	_, err := ReadData(c)
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("got %v, want *ParseError", err)
+	}
+	if pe.Tag != "MEAS" || pe.Index != 0 || pe.Offset != measOff {
+		t.Errorf("got %+v, want MEAS 0 at %d", pe, measOff)
+	}
+
+	c = testFile()
+	c[len(c)-30+3] = 1
+	_, err = ReadData(c)
+	pe, ok = err.(*ParseError)
+	if !ok {
+		t.Fatalf("got %v, want *ParseError", err)
+	}
+	if pe.Tag != "MEAS" || pe.Offset != len(c)-30 {
+		t.Errorf("got %+v, want MEAS element at %d", pe, len(c)-30)
+	}
+}
+
+func TestReadDataTruncated(t *testing.T) {
+	c := testFile()
+	for i := 0; i < len(c); i++ {
+		if _, err := ReadData(c[:i]); err == nil {
+			t.Errorf("ReadData of %d bytes succeeded", i)
+		}
+	}
+}
+
+func FuzzReadData(f *testing.F) {
+	c := testFile()
+	f.Add(c)
+	f.Add(c[:len(c)-10])
+
+	// An ornament element of 3 bytes, too short for its subtype.
+	c = testFile()
+	c[len(c)-30+2] = TYPE_ORNAMENT << 4
+	c[len(c)-30+3] = 3
+	f.Add(c)
+
+	// A triplet note without the denominator.
+	c = testFile()
+	c[len(c)-30+13] = 0x02
+	f.Add(c)
+	f.Fuzz(func(t *testing.T, c []byte) {
+		for _, m := range []Mode{Normal, Strict, Lenient} {
+			ReadDataOptions(c, &DecodeOptions{Mode: m})
+		}
+	})
+}
+
+// twoNoteFile returns testFile with a second note in the measure.
+func twoNoteFile() []byte {
+	c := testFile()
+	measOff := len(c) - 62 - 30
+	note := append([]byte{}, c[len(c)-30:len(c)-2]...)
+	c = append(c[:len(c)-2], note...)
+	c = append(c, 0xff, 0xff)
+	binary.LittleEndian.PutUint32(c[measOff+4:], uint32(2*len(note)+2))
+	return c
+}
+
+func TestReadDataModes(t *testing.T) {
+	c := testFile()
+	c[len(c)-2] = 0
+	if _, err := ReadDataOptions(c, &DecodeOptions{Mode: Strict}); err == nil {
+		t.Errorf("Strict: missing end marker accepted")
+	}
+	d, err := ReadData(c)
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	if len(d.Anomalies) != 1 || len(d.Measures[0].Elems) != 1 {
+		t.Errorf("got anomalies %v, %d elements, want 1 anomaly and 1 element", d.Anomalies, len(d.Measures[0].Elems))
+	}
+
+	c = twoNoteFile()
+	if d, err := ReadData(c); err != nil || len(d.Measures[0].Elems) != 2 {
+		t.Fatalf("ReadData: %v", err)
+	}
+	elemOff := len(c) - 2 - 2*28
+	c[elemOff+3] = 1
+	if _, err := ReadData(c); err == nil {
+		t.Errorf("Normal: bad element size accepted")
+	}
+	d, err = ReadDataOptions(c, &DecodeOptions{Mode: Lenient})
+	if err != nil {
+		t.Fatalf("Lenient: %v", err)
+	}
+	if len(d.Anomalies) != 1 || d.Anomalies[0].Offset != elemOff {
+		t.Errorf("got anomalies %v, want one at %d", d.Anomalies, elemOff)
+	}
+	if es := d.Measures[0].Elems; len(es) != 1 || es[0].Offset != elemOff+28 {
+		t.Errorf("got %d elements, want the second note", len(es))
+	}
+
+	// A measure size that does not end at the end marker.
+	c = twoNoteFile()
+	binary.LittleEndian.PutUint32(c[len(c)-2-2*28-62+4:], 20)
+	d, err = ReadDataOptions(c, &DecodeOptions{Mode: Lenient})
+	if err != nil {
+		t.Fatalf("Lenient: %v", err)
+	}
+	if len(d.Anomalies) != 1 || len(d.Measures[0].Elems) != 2 {
+		t.Errorf("got anomalies %v, %d elements, want 1 anomaly and 2 elements", d.Anomalies, len(d.Measures[0].Elems))
+	}
+}
+
+func TestReadDataBadValues(t *testing.T) {
+	note := len(testFile()) - 30
+	lineStaff := 194 + 242 + 34 + 8 + 26
+	for _, tc := range []struct {
+		name   string
+		change map[int]byte
+
+		// lenient is the number of elements left in Lenient
+		// mode. Bad elements are dropped; a line staff is kept.
+		lenient int
+	}{
+		{"face value", map[int]byte{note + 5: 0xf}, 0},
+		{"tuplet", map[int]byte{note + 13: 0x02}, 0},
+		{"key change", map[int]byte{note + 2: TYPE_KEYCHANGE << 4, note + 5: MaxKey + 1}, 0},
+		{"line key", map[int]byte{lineStaff + 2: MaxKey + 1}, 1},
+	} {
+		c := testFile()
+		for off, v := range tc.change {
+			c[off] = v
+		}
+		if _, err := ReadDataOptions(c, &DecodeOptions{Mode: Strict}); err == nil {
+			t.Errorf("%s: Strict: accepted", tc.name)
+		}
+		d, err := ReadData(c)
+		if err != nil {
+			t.Fatalf("%s: Normal: %v", tc.name, err)
+		}
+		if len(d.Anomalies) != 1 || len(d.Measures[0].Elems) != 1 {
+			t.Errorf("%s: Normal: got anomalies %v, %d elements, want 1 and 1", tc.name, d.Anomalies, len(d.Measures[0].Elems))
+		}
+		d, err = ReadDataOptions(c, &DecodeOptions{Mode: Lenient})
+		if err != nil {
+			t.Fatalf("%s: Lenient: %v", tc.name, err)
+		}
+		if len(d.Anomalies) != 1 || len(d.Measures[0].Elems) != tc.lenient {
+			t.Errorf("%s: Lenient: got anomalies %v, %d elements, want 1 and %d", tc.name, d.Anomalies, len(d.Measures[0].Elems), tc.lenient)
+		}
+	}
+}
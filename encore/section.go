@@ -0,0 +1,76 @@
+package encore
+
+import (
+	"encoding/binary"
+)
+
+// Section is a block following the measures that is not decoded
+// further.
+type Section struct {
+	// Tag is the 4 letter tag the section starts with, or empty
+	// for data that does not start with a tag.
+	Tag string
+
+	Offset int
+
+	// Size of the section in bytes, including the tag.
+	Size int
+
+	Raw []byte
+}
+
+// isTag returns if b starts with 4 uppercase letters or digits.
+func isTag(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+	for _, c := range b[:4] {
+		if !(('0' <= c && c <= '9') || ('A' <= c && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+// sectionSize returns the size of the section at the start of b. If
+// b starts with a tag followed by a size that ends at another tag or
+// at the end of the data, that is used, like for LINE and MEAS.
+// Otherwise the section runs up to the next tag.
+func sectionSize(b []byte) int {
+	if isTag(b) && len(b) >= 8 {
+		sz := 8 + int(binary.LittleEndian.Uint32(b[4:]))
+		if sz >= 8 && sz <= len(b) && (sz == len(b) || isTag(b[sz:])) {
+			return sz
+		}
+	}
+	i := 1
+	if isTag(b) {
+		i = 4
+	}
+	for ; i < len(b); i++ {
+		if isTag(b[i:]) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// readSections splits the data c starting at off into sections.
+func readSections(c []byte, off int) []*Section {
+	var result []*Section
+	for len(c) > 0 {
+		sz := sectionSize(c)
+		s := &Section{
+			Offset: off,
+			Size:   sz,
+			Raw:    c[:sz],
+		}
+		if isTag(c) {
+			s.Tag = string(c[:4])
+		}
+		result = append(result, s)
+		c = c[sz:]
+		off += sz
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+package encore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestExtraSections(t *testing.T) {
+	c := testFile()
+	end := len(c)
+	c = append(c, "\x00\x01TEXT\x03\x00\x00\x00abcTITL title"...)
+	d, err := ReadData(c)
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+
+	want := []Section{
+		{Tag: "", Offset: end, Size: 2},
+		{Tag: "TEXT", Offset: end + 2, Size: 11},
+		{Tag: "TITL", Offset: end + 13, Size: 10},
+	}
+	if len(d.Extra) != len(want) {
+		t.Fatalf("got %d sections, want %d", len(d.Extra), len(want))
+	}
+	for i, s := range d.Extra {
+		if s.Tag != want[i].Tag || s.Offset != want[i].Offset || s.Size != want[i].Size ||
+			!bytes.Equal(s.Raw, c[s.Offset:s.Offset+s.Size]) {
+			t.Errorf("section %d: got %+v, want %+v", i, s, want[i])
+		}
+	}
+
+	if got := d.Locate(end + 14).String(); got != "TITL 2 Section byte 1 (unmapped)" {
+		t.Errorf("Locate: got %q", got)
+	}
+	if err := Verify(c); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestTexts(t *testing.T) {
+	c := append(testFile(), "TEXT\x18\x00\x00\x00Sonata\x00\x00Op. 2\x00Beethoven\x00"...)
+	d, err := ReadData(c)
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	want := []string{"Sonata", "Op. 2", "Beethoven"}
+	if fmt.Sprint(d.Text) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", d.Text, want)
+	}
+}
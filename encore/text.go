@@ -0,0 +1,40 @@
+package encore
+
+import (
+	"bytes"
+	"strings"
+)
+
+// The layout of the TEXT sections is not known. The strings are
+// stored NUL terminated after the 8 byte tag and size. Which string
+// is the title, composer etc. remains to be confirmed with real
+// files, so they are only kept in order in Data.Text.
+
+// texts returns the NUL separated strings in a TEXT section.
+func (s *Section) texts(cs Charset) []string {
+	if len(s.Raw) < 8 {
+		return nil
+	}
+	var result []string
+	for _, f := range bytes.Split(s.Raw[8:], []byte{0}) {
+		var printable []byte
+		for _, c := range f {
+			if c >= ' ' && c != 127 {
+				printable = append(printable, c)
+			}
+		}
+		if t := strings.TrimSpace(cs.Decode(printable)); t != "" {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// readTexts fills in d.Text from its TEXT sections.
+func (d *Data) readTexts() {
+	for _, s := range d.Extra {
+		if s.Tag == "TEXT" {
+			d.Text = append(d.Text, s.texts(d.Charset)...)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package encore
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Mismatch is returned by Verify if re-encoding a file does not
+// reproduce it.
+type Mismatch struct {
+	// Offset of the first differing byte.
+	Offset int
+
+	// Original and re-encoded byte, or -1 past the end of the data.
+	Want, Got int
+
+	// Location of Offset in the original file.
+	Location *Location
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("offset %d: got %d, want %d in %v", m.Offset, m.Got, m.Want, m.Location)
+}
+
+// Verify parses c, encodes it again and compares the result with c.
+// It returns a *Mismatch for the first differing byte, or the error
+// from parsing or encoding.
+func Verify(c []byte) error {
+	d, err := ReadData(c)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(d); err != nil {
+		return err
+	}
+	return d.compare(buf.Bytes())
+}
+
+// compare returns a *Mismatch for the first difference between the
+// file d was decoded from and out.
+func (d *Data) compare(out []byte) error {
+	c := d.Raw
+	n := len(c)
+	if len(out) < n {
+		n = len(out)
+	}
+	at := func(b []byte, i int) int {
+		if i < len(b) {
+			return int(b[i])
+		}
+		return -1
+	}
+	for i := 0; i <= n; i++ {
+		if i == n && len(c) == len(out) {
+			break
+		}
+		if i < n && c[i] == out[i] {
+			continue
+		}
+		return &Mismatch{
+			Offset:   i,
+			Want:     at(c, i),
+			Got:      at(out, i),
+			Location: d.Locate(i),
+		}
+	}
+	return nil
+}
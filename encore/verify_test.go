@@ -0,0 +1,49 @@
+package encore
+
+import (
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	c := testFile()
+	if err := Verify(c); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// A byte after the end marker is dropped on encoding.
+	meas := len(c) - 62 - 30
+	c[meas+4]++
+	c = append(c[:len(c)-2], 0xff, 0xff, 0)
+	err := Verify(c)
+	m, ok := err.(*Mismatch)
+	if !ok {
+		t.Fatalf("got %v, want *Mismatch", err)
+	}
+	if m.Offset != meas+4 || m.Location.Block != "MEAS" || m.Location.Field != "VarSize" {
+		t.Errorf("got %v, want MEAS VarSize at %d", m, meas+4)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	c := testFile()
+	d, err := ReadData(c)
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	note := d.Measures[0].Elems[0].Offset
+	for _, tc := range []struct {
+		off  int
+		want string
+	}{
+		{0x32, "SCOW Header byte 50 (StaffCount)"},
+		{194 + 9, "TK00 0 Staff byte 9 (Name)"},
+		{194 + 242 + 34 + 8 + 26 + 8, "LINE 0 elem 0 LineStaffData byte 8 (StaffIdx)"},
+		{note + 15, "MEAS 0 elem 0 Note byte 15 (SemitonePitch)"},
+		{note + 27, "MEAS 0 elem 0 Note byte 27 (unmapped)"},
+		{note + 28, "MEAS 0 end marker byte 0 (unmapped)"},
+	} {
+		if got := d.Locate(tc.off).String(); got != tc.want {
+			t.Errorf("Locate(%d) = %q, want %q", tc.off, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package encore
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// BlockLayout describes the fixed size part of a block.
+type BlockLayout struct {
+	// Tags the block may start with. New blocks get the first one.
+	Tags []string
+
+	Size int
+}
+
+// Layout describes the blocks of one version of the file format.
+type Layout struct {
+	Version string
+
+	// Magic is the tag of the file header.
+	Magic string
+
+	// FormatVersions are the values of Header.FormatVersion
+	// seen with this layout. Others are read with a warning.
+	FormatVersions []byte
+
+	Header, Staff, Page, Line, Measure BlockLayout
+}
+
+// tagLayout returns the layout given by the "want" and "fixed" tags
+// on the Raw field of dest.
+func tagLayout(dest interface{}) BlockLayout {
+	f, _ := reflect.TypeOf(dest).Elem().FieldByName("Raw")
+	l := BlockLayout{Size: fixedSize(dest)}
+	if want := f.Tag.Get("want"); want != "" {
+		l.Tags = []string{want}
+	}
+	return l
+}
+
+// Layout455 is the layout of the 4.55 demo version, as described by
+// the struct tags in data.go. The demo leaves the version byte zero;
+// 4 is a guess for the full version.
+var Layout455 = &Layout{
+	Version:        "4.55",
+	Magic:          "SCOW",
+	FormatVersions: []byte{0, 4},
+	Header:         tagLayout(&Header{}),
+	Staff: BlockLayout{
+		Tags: []string{"TK00", "TK01"},
+		Size: fixedSize(&Staff{}),
+	},
+	Page:    tagLayout(&Page{}),
+	Line:    tagLayout(&Line{}),
+	Measure: tagLayout(&Measure{}),
+}
+
+// Layouts lists the supported versions. Other versions can be added
+// as their layouts are reverse engineered.
+var Layouts = []*Layout{Layout455}
+
+// UnsupportedVersionError is returned for files whose header magic
+// does not match any of Layouts.
+type UnsupportedVersionError struct {
+	Magic string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	if strings.HasPrefix(e.Magic, "SCO") {
+		return fmt.Sprintf("unsupported Encore version %q", e.Magic)
+	}
+	return fmt.Sprintf("not an Encore file: magic %q", e.Magic)
+}
+
+// DetectLayout returns the layout for a file starting with header.
+// Only the magic is used: the format version in the header is
+// checked against FormatVersions once the header is read.
+func DetectLayout(header []byte) (*Layout, error) {
+	magic := string(header)
+	if len(magic) > 4 {
+		magic = magic[:4]
+	}
+	for _, l := range Layouts {
+		if l.Magic == magic {
+			return l, nil
+		}
+	}
+	return nil, &UnsupportedVersionError{Magic: magic}
+}
+
+// checkVersion reports a format version that was not seen with the
+// layout. The layouts of 3.x and 5.x files, and where they keep the
+// version, are not confirmed, so the file is read anyway.
+func (f *Data) checkVersion() error {
+	v := f.Header.FormatVersion
+	for _, fv := range f.Layout.FormatVersions {
+		if fv == v {
+			return nil
+		}
+	}
+	return f.anomaly(&ParseError{
+		Tag:    f.Layout.Magic,
+		Offset: f.Header.Offset + 4,
+		Msg:    fmt.Sprintf("format version %d not known; reading as %s", v, f.Layout.Version),
+	})
+}
+
+// block returns the layout for the block type of dest.
+func (l *Layout) block(dest interface{}) BlockLayout {
+	switch dest.(type) {
+	case *Header:
+		return l.Header
+	case *Staff:
+		return l.Staff
+	case *Page:
+		return l.Page
+	case *Line:
+		return l.Line
+	case *Measure:
+		return l.Measure
+	}
+	panic(fmt.Sprintf("no layout for %T", dest))
+}
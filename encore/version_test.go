@@ -0,0 +1,71 @@
+package encore
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDetectLayout(t *testing.T) {
+	d, err := ReadData(testFile())
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	if d.Layout != Layout455 {
+		t.Errorf("got layout %v, want 4.55", d.Layout.Version)
+	}
+
+	c := testFile()
+	c[4] = 4
+	if d, err := ReadData(c); err != nil || d.Layout != Layout455 || d.Header.FormatVersion != 4 || len(d.Anomalies) != 0 {
+		t.Errorf("version 4: got %v, want 4.55 layout", err)
+	}
+
+	// Other versions are read as 4.55, with a warning.
+	for _, v := range []byte{3, 5, 9} {
+		c := testFile()
+		c[4] = v
+		d, err := ReadData(c)
+		if err != nil || d.Layout != Layout455 || len(d.Anomalies) != 1 {
+			t.Errorf("version %d: got %v, want 4.55 layout with an anomaly", v, err)
+		} else if msg := d.Anomalies[0].Msg; msg != fmt.Sprintf("format version %d not known; reading as 4.55", v) {
+			t.Errorf("version %d: got %q", v, msg)
+		}
+		if _, err := Decode(bytes.NewReader(c), &DecodeOptions{Mode: Strict}); err == nil {
+			t.Errorf("version %d: strict mode accepted the file", v)
+		}
+	}
+
+	for _, tc := range []struct {
+		magic string
+		msg   string
+	}{
+		{"SCO5", "unsupported Encore version"},
+		{"PK\x03\x04", "not an Encore file"},
+	} {
+		c := testFile()
+		copy(c, tc.magic)
+		_, err := Decode(bytes.NewReader(c), nil)
+		e, ok := err.(*UnsupportedVersionError)
+		if !ok || e.Magic != tc.magic {
+			t.Errorf("%q: got %v, want UnsupportedVersionError", tc.magic, err)
+		} else if !strings.Contains(e.Error(), tc.msg) {
+			t.Errorf("%q: got %q, want %q", tc.magic, e, tc.msg)
+		}
+	}
+}
+
+func TestStaffTags(t *testing.T) {
+	c := testFile()
+	copy(c[194:], "TK01")
+	if _, err := ReadData(c); err != nil {
+		t.Errorf("TK01: %v", err)
+	}
+
+	copy(c[194:], "TK99")
+	_, err := ReadData(c)
+	if pe, ok := err.(*ParseError); !ok || !strings.Contains(pe.Msg, "TK00 or TK01") {
+		t.Errorf("TK99: got %v, want ParseError", err)
+	}
+}
@@ -0,0 +1,172 @@
+package encore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Encoder writes Data as an Encore file.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes d. Blocks and elements are written from their Raw
+// bytes, so unknown data is preserved, with the known fields stored
+// on top. Blocks and elements without Raw start out as zero bytes.
+//
+// Lazily decoded measures are loaded first. The header counts, the
+// VarSize of lines and measures, and the element Size are updated in
+// d to match what is written, so elements may be added or removed
+// before encoding.
+func (enc *Encoder) Encode(d *Data) error {
+	if err := d.LoadMeasures(); err != nil {
+		return err
+	}
+
+	layout := d.Layout
+	if layout == nil {
+		layout = Layout455
+	}
+
+	var out []byte
+	if len(d.Staff) > math.MaxUint8 || len(d.Pages) > math.MaxInt16 ||
+		len(d.Lines) > math.MaxInt16 || len(d.Measures) > math.MaxInt16 {
+		return fmt.Errorf("too many blocks for header: %d staffs, %d pages, %d lines, %d measures",
+			len(d.Staff), len(d.Pages), len(d.Lines), len(d.Measures))
+	}
+	h := &d.Header
+	h.StaffCount = byte(len(d.Staff))
+	h.PageCount = int16(len(d.Pages))
+	h.LineCount = int16(len(d.Lines))
+	h.MeasureCount = int16(len(d.Measures))
+	out = append(out, storeBlock(h.Raw, h, layout.Header)...)
+
+	for _, s := range d.Staff {
+		out = append(out, storeBlock(s.Raw, s, layout.Staff)...)
+	}
+	for _, p := range d.Pages {
+		out = append(out, storeBlock(p.Raw, p, layout.Page)...)
+	}
+	for _, l := range d.Lines {
+		v := l.encodeVarData()
+		l.VarSize = uint32(len(v))
+		out = append(out, storeBlock(l.Raw, l, layout.Line)...)
+		out = append(out, v...)
+	}
+	for _, m := range d.Measures {
+		v, err := m.encodeElems()
+		if err != nil {
+			return err
+		}
+		m.VarSize = int32(len(v))
+		out = append(out, storeBlock(m.Raw, m, layout.Measure)...)
+		out = append(out, v...)
+	}
+	for _, s := range d.Extra {
+		out = append(out, s.Raw...)
+	}
+
+	_, err := enc.w.Write(out)
+	return err
+}
+
+func (l *Line) encodeVarData() []byte {
+	v := make([]byte, 26)
+	copy(v, l.VarData)
+	storeFields(v, &l.LineData)
+	for _, s := range l.Staffs {
+		raw := make([]byte, 30)
+		copy(raw, s.Raw)
+		storeFields(raw, s)
+		v = append(v, raw...)
+	}
+	return v
+}
+
+func (m *Measure) encodeElems() ([]byte, error) {
+	var v []byte
+	for i, e := range m.Elems {
+		raw, err := e.encode()
+		if err != nil {
+			return nil, fmt.Errorf("measure %d, element %d: %v", m.Id, i, err)
+		}
+		v = append(v, raw...)
+	}
+	return append(v, endMarker...), nil
+}
+
+func (e *MeasElem) encode() ([]byte, error) {
+	sz := len(e.Raw)
+	if e.Raw == nil {
+		sz = int(e.Size)
+	}
+	if b, ok := e.TypeSpecific.(*Beam); ok && (sz-14)/16 != len(b.SubBeams) {
+		sz = 14 + 16*len(b.SubBeams)
+	}
+	if sz < 4 || sz > 255 {
+		return nil, fmt.Errorf("invalid element size %d", sz)
+	}
+
+	raw := make([]byte, sz)
+	copy(raw, e.Raw)
+	e.Size = byte(sz)
+	storeFields(raw, e)
+	if e.TypeSpecific != nil {
+		storeFields(raw, e.TypeSpecific)
+	}
+	if b, ok := e.TypeSpecific.(*Beam); ok {
+		for i := range b.SubBeams {
+			storeFields(raw[14+16*i:], &b.SubBeams[i])
+		}
+	}
+	return raw, nil
+}
+
+// storeBlock returns a copy of raw, or a new block with layout bl if
+// raw is nil, with the fields of dest stored into it.
+func storeBlock(raw []byte, dest interface{}, bl BlockLayout) []byte {
+	if raw == nil {
+		raw = make([]byte, bl.Size)
+		if len(bl.Tags) > 0 {
+			copy(raw, bl.Tags[0])
+		}
+	} else {
+		raw = append([]byte(nil), raw...)
+	}
+	storeFields(raw, dest)
+	return raw
+}
+
+// storeFields is the inverse of fillFields: it writes the fields
+// with an offset tag into raw. Fields that do not fit are skipped.
+func storeFields(raw []byte, src interface{}) {
+	v := reflect.ValueOf(src).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Anonymous {
+			storeFields(raw, v.Field(i).Addr().Interface())
+			continue
+		}
+		f := v.Field(i)
+		offStr := v.Type().Field(i).Tag.Get("offset")
+		if offStr == "" {
+			continue
+		}
+
+		off, _ := strconv.ParseInt(offStr, 0, 64)
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, f.Interface())
+		if int(off)+buf.Len() > len(raw) {
+			continue
+		}
+		copy(raw[off:], buf.Bytes())
+	}
+}
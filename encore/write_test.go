@@ -0,0 +1,79 @@
+package encore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func encode(t *testing.T, d *Data) []byte {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(d); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	c := append(testFile(), "TEXT trailing"...)
+	d, err := ReadData(c)
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	if got := encode(t, d); !bytes.Equal(got, c) {
+		t.Errorf("round trip mismatch:\ngot  %q\nwant %q", got, c)
+	}
+}
+
+func TestEncodeModified(t *testing.T) {
+	d, err := ReadData(testFile())
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	m := d.Measures[0]
+	n := m.Elems[0].TypeSpecific.(*Note)
+	n.SemitonePitch = 62
+	m.Elems = append(m.Elems, &MeasElem{
+		Tick:         240,
+		TypeVoice:    TYPE_REST << 4,
+		Size:         20,
+		TypeSpecific: &Rest{WithDuration: WithDuration{FaceValue: 3}},
+	})
+	d.Measures = append(d.Measures, &Measure{
+		DurTicks: 960,
+	})
+
+	d2, err := ReadData(encode(t, d))
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	if got := d2.Header.MeasureCount; got != 2 {
+		t.Errorf("got MeasureCount %d, want 2", got)
+	}
+	if got := len(d2.Measures[0].Elems); got != 2 {
+		t.Fatalf("got %d elements, want 2", got)
+	}
+	if got := d2.Measures[0].Elems[0].TypeSpecific.(*Note).SemitonePitch; got != 62 {
+		t.Errorf("got pitch %d, want 62", got)
+	}
+	r, ok := d2.Measures[0].Elems[1].TypeSpecific.(*Rest)
+	if !ok || r.FaceValue != 3 || d2.Measures[0].Elems[1].Tick != 240 {
+		t.Errorf("got %+v, want quarter rest at 240", d2.Measures[0].Elems[1].TypeSpecific)
+	}
+}
+
+func TestEncodeTooManyBlocks(t *testing.T) {
+	d, err := ReadData(testFile())
+	if err != nil {
+		t.Fatalf("ReadData: %v", err)
+	}
+	for len(d.Staff) < 256 {
+		d.Staff = append(d.Staff, &Staff{})
+	}
+	want := d.Header.String()
+	if err := NewEncoder(&bytes.Buffer{}).Encode(d); err == nil {
+		t.Fatalf("Encode of %d staffs succeeded", len(d.Staff))
+	}
+	if got := d.Header.String(); got != want {
+		t.Errorf("header changed by failed Encode: got %s, want %s", got, want)
+	}
+}
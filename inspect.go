@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// inspectWidth is the maximum number of bytes on a line of the
+// dump.
+const inspectWidth = 8
+
+// byteLabel returns the label for byte off of d, and whether it is
+// covered by a known field. Bytes of elements and line staves are
+// prefixed with their index, so adjacent ones get separate runs.
+func byteLabel(d *encore.Data, off int) (string, bool) {
+	loc := d.Locate(off)
+	label := loc.Struct
+	if loc.Elem >= 0 {
+		label = fmt.Sprintf("elem %d %s", loc.Elem, label)
+	}
+	if loc.Field == "" {
+		return label, false
+	}
+	return label + "." + loc.Field, true
+}
+
+// leValue returns b as a little endian unsigned number.
+func leValue(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// writeInspect writes a hex dump of sel, with each run of bytes
+// labelled by the struct field covering it, and fields of up to 4
+// bytes also with their unsigned value. Unmapped bytes are marked
+// with "**".
+func writeInspect(w io.Writer, d *encore.Data, sel *selection) {
+	fmt.Fprintf(w, "%v\n", sel)
+	end := sel.Offset + sel.Size
+	cont := false
+	for off := sel.Offset; off < end; {
+		label, mapped := byteLabel(d, off)
+		n := 1
+		split := false
+		for off+n < end {
+			l, m := byteLabel(d, off+n)
+			if l != label || m != mapped {
+				break
+			}
+			if n == inspectWidth {
+				split = true
+				break
+			}
+			n++
+		}
+		run := d.Raw[off : off+n]
+
+		var hex []string
+		for _, c := range run {
+			hex = append(hex, fmt.Sprintf("%02x", c))
+		}
+		mark := "  "
+		if mapped && !cont && !split && n <= 4 {
+			label += fmt.Sprintf(" = %d", leValue(run))
+		} else if !mapped {
+			mark = "**"
+			label += " (unmapped)"
+		}
+		fmt.Fprintf(w, "%5d %7d  %-*s %s %s\n", off-sel.Offset, off,
+			3*inspectWidth-1, strings.Join(hex, " "), mark, label)
+		off += n
+		cont = split
+	}
+}
+
+// inspect prints an annotated hex dump of part of an Encore file.
+func inspect(args []string) {
+	d, err := readEnc(args[0])
+	if err != nil {
+		log.Fatalf("%s: %v", args[0], err)
+	}
+	sel, rest, err := parseSelector(d, args[1:])
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("unexpected arguments %q", rest)
+	}
+	if err != nil {
+		log.Fatalf("inspect: %v", err)
+	}
+	writeInspect(os.Stdout, d, sel)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// inspectRuns returns the lines of the inspect dump of sel by their
+// offset relative to the start of sel.
+func inspectRuns(t *testing.T, d *encore.Data, sel *selection) map[int]string {
+	var buf bytes.Buffer
+	writeInspect(&buf, d, sel)
+	runs := map[int]string{}
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")[1:] {
+		var rel, off int
+		if _, err := fmt.Sscanf(l, "%d %d", &rel, &off); err != nil {
+			t.Fatalf("line %q: %v", l, err)
+		}
+		runs[rel] = l
+	}
+	return runs
+}
+
+func TestWriteInspect(t *testing.T) {
+	d := testScore(t, 2, 1,
+		testElem{0, 0, 0, 0, testRest(3)},
+		testElem{0, 240, 0, 0, testRest(3)},
+	)
+
+	// Two adjacent rests.
+	a, b := d.Measures[0].Elems[0], d.Measures[0].Elems[1]
+	if a.Offset+len(a.Raw) != b.Offset {
+		t.Fatalf("elements not adjacent: %d+%d, %d", a.Offset, len(a.Raw), b.Offset)
+	}
+	runs := inspectRuns(t, d, &selection{"rests", a.Offset, len(a.Raw) + len(b.Raw)})
+	for rel, l := range runs {
+		want := "elem 0 "
+		if rel >= len(a.Raw) {
+			want = "elem 1 "
+		}
+		if !strings.Contains(l, want) {
+			t.Errorf("line %q: want %q", l, want)
+		}
+	}
+	if l := runs[len(a.Raw)]; !strings.Contains(l, "elem 1 MeasElem.Tick = 240") {
+		t.Errorf("got %q, want the tick of the second rest", l)
+	}
+
+	// Two line staves, which start and end with unmapped bytes.
+	sel, _, err := parseSelector(d, []string{"line", "0"})
+	if err != nil {
+		t.Fatalf("parseSelector: %v", err)
+	}
+	l := d.Lines[0]
+	start := len(l.Raw) + 26 + len(l.Staffs[0].Raw)
+	runs = inspectRuns(t, d, sel)
+	if got := runs[start]; !strings.Contains(got, "elem 1 LineStaffData (unmapped)") {
+		t.Errorf("got %q, want the start of the second line staff", got)
+	}
+}
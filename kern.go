@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/encore"
+	"github.com/hanwen/go-enc2ly/lily"
+)
+
+// kernNote is a note or rest in a **kern spine.
+type kernNote struct {
+	// pitch is nil for rests.
+	pitch *lily.Pitch
+	recip string
+
+	tieStart, tieEnd bool
+
+	// invisible rests fill the gaps in a voice.
+	invisible bool
+}
+
+func (n *kernNote) String() string {
+	if n.pitch == nil {
+		s := n.recip + "r"
+		if n.invisible {
+			s += "yy"
+		}
+		return s
+	}
+	s := n.recip + kernPitch(*n.pitch)
+	switch {
+	case n.tieStart && n.tieEnd:
+		s += "_"
+	case n.tieStart:
+		s = "[" + s
+	case n.tieEnd:
+		s += "]"
+	}
+	return s
+}
+
+// kernToken is an interpretation, or the notes of a chord, at an
+// absolute tick.
+type kernToken struct {
+	measure int
+	tick    int
+	interp  string
+	notes   []*kernNote
+}
+
+func (t *kernToken) String() string {
+	if t.interp != "" {
+		return t.interp
+	}
+	var s []string
+	for _, n := range t.notes {
+		s = append(s, n.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// kernVoice collects the tokens of a voice, which is written as a
+// spine, or as a subspine if the staff has more voices.
+type kernVoice struct {
+	measures []*encore.Measure
+	tokens   []*kernToken
+
+	// attributes is set if key and clef changes should be
+	// recorded. initKey and initClef are the initial ones.
+	attributes        bool
+	initKey, initClef string
+
+	// next is the tick after the last note or rest.
+	next int
+
+	tuplet    *encore.Beam
+	lastChord *kernToken
+
+	// tied has the pitches of notes with an open tie.
+	tied map[int]bool
+}
+
+func newKernVoice(measures []*encore.Measure, attributes bool) *kernVoice {
+	return &kernVoice{
+		measures:   measures,
+		attributes: attributes,
+		tied:       map[int]bool{},
+	}
+}
+
+func (v *kernVoice) startMeasure(e *encore.MeasElem, prev *encore.Measure) {}
+func (v *kernVoice) barCheck()                                             {}
+func (v *kernVoice) timeSignature(m *encore.Measure)                       {}
+
+func (v *kernVoice) skip(ticks int) {
+	v.fill(v.next, v.next+ticks, true)
+}
+
+// fill adds rests from tick start to end, split at the measure lines.
+func (v *kernVoice) fill(start, end int, invisible bool) {
+	for start < end {
+		i := sort.Search(len(v.measures), func(i int) bool {
+			return v.measures[i].AbsTick > start
+		}) - 1
+		if i < 0 {
+			break
+		}
+		m := v.measures[i]
+		to := m.AbsTick + int(m.DurTicks)
+		if to > end {
+			to = end
+		}
+		if to <= start {
+			break
+		}
+		v.tokens = append(v.tokens, &kernToken{
+			measure: m.Id,
+			tick:    start,
+			notes:   []*kernNote{{recip: kernTicks(to - start), invisible: invisible}},
+		})
+		start = to
+	}
+	if end > v.next {
+		v.next = end
+	}
+}
+
+func (v *kernVoice) interp(e *encore.MeasElem, initial *string, s string) {
+	if !v.attributes {
+		return
+	}
+	if *initial == "" {
+		*initial = s
+		return
+	}
+	v.tokens = append(v.tokens, &kernToken{
+		measure: e.Measure.Id,
+		tick:    e.AbsTick(),
+		interp:  s,
+	})
+}
+
+func (v *kernVoice) key(e *encore.MeasElem, key byte) {
+	v.interp(e, &v.initKey, kernKey(key))
+}
+
+func (v *kernVoice) clef(e *encore.MeasElem, clef byte) {
+	v.interp(e, &v.initClef, kernClef(clef))
+}
+
+func (v *kernVoice) tupletStart(e *encore.MeasElem, b *encore.Beam) {
+	v.tuplet = b
+}
+
+func (v *kernVoice) tupletEnd() {
+	v.tuplet = nil
+}
+
+func (v *kernVoice) tie(e *encore.MeasElem) {
+	if v.lastChord == nil {
+		return
+	}
+	for _, n := range v.lastChord.notes {
+		n.tieStart = true
+		v.tied[n.pitch.SemitonePitch()] = true
+	}
+}
+
+func (v *kernVoice) recip(d lily.Duration, w *encore.WithDuration) string {
+	actual, normal := 1, 1
+	if v.tuplet != nil {
+		actual, normal = tupletRatio(v.tuplet, w)
+	}
+	return kernDuration(d, actual, normal)
+}
+
+func (v *kernVoice) add(e *encore.MeasElem, n *kernNote) *kernToken {
+	t := &kernToken{
+		measure: e.Measure.Id,
+		tick:    e.AbsTick(),
+		notes:   []*kernNote{n},
+	}
+	v.tokens = append(v.tokens, t)
+	if end := e.AbsTick() + e.GetDurationTick(); end > v.next {
+		v.next = end
+	}
+	return t
+}
+
+func (v *kernVoice) note(e *encore.MeasElem, n *encore.Note, clef byte, chord bool) {
+	p, d := convertNote(n, basePitch(clef))
+	kn := &kernNote{pitch: &p, recip: v.recip(d, &n.WithDuration)}
+	if s := p.SemitonePitch(); v.tied[s] {
+		kn.tieEnd = true
+		delete(v.tied, s)
+	}
+	if chord && v.lastChord != nil {
+		v.lastChord.notes = append(v.lastChord.notes, kn)
+		return
+	}
+	v.lastChord = v.add(e, kn)
+}
+
+func (v *kernVoice) rest(e *encore.MeasElem, r *encore.Rest) {
+	v.add(e, &kernNote{recip: v.recip(convertRest(r), &r.WithDuration)})
+	v.lastChord = nil
+}
+
+// kernDuration returns the reciprocal duration of d, played
+// actual:normal as in a tuplet.
+func kernDuration(d lily.Duration, actual, normal int) string {
+	var s string
+	switch {
+	case d.DurationLog == -1:
+		s = "0"
+	case d.DurationLog == -2:
+		s = "00"
+	case d.DurationLog >= 0:
+		r := big.NewRat(int64(1)<<uint(d.DurationLog)*int64(actual), int64(normal))
+		s = r.Num().String()
+		if !r.IsInt() {
+			s += "%" + r.Denom().String()
+		}
+	}
+	return s + strings.Repeat(".", d.Dots)
+}
+
+// kernTicks returns the reciprocal duration of a rest of the given
+// number of ticks.
+func kernTicks(ticks int) string {
+	const whole = 4 * 240
+	switch {
+	case whole%ticks == 0:
+		return fmt.Sprint(whole / ticks)
+	case whole*3%(2*ticks) == 0:
+		return fmt.Sprint(whole*3/(2*ticks)) + "."
+	case ticks == 2*whole:
+		return "0"
+	}
+	return strings.Replace(big.NewRat(whole, int64(ticks)).String(), "/", "%", 1)
+}
+
+// kernPitch returns p in **kern notation, where c is middle C, cc
+// the octave above and C the octave below.
+func kernPitch(p lily.Pitch) string {
+	p.Normalize()
+	name := string("cdefgab"[p.Notename])
+	var s string
+	if p.Octave >= 0 {
+		s = strings.Repeat(name, p.Octave+1)
+	} else {
+		s = strings.Repeat(strings.ToUpper(name), -p.Octave)
+	}
+	if p.Alteration > 0 {
+		s += strings.Repeat("#", p.Alteration)
+	} else if p.Alteration < 0 {
+		s += strings.Repeat("-", -p.Alteration)
+	}
+	return s
+}
+
+// kernKey returns the key signature interpretation for an Encore
+// key. Keys beyond 7 sharps or flats are clamped.
+func kernKey(key byte) string {
+	f := keyFifths(key)
+	if f > 7 {
+		f = 7
+	}
+	if f >= 0 {
+		return "*k[" + "f#c#g#d#a#e#b#"[:2*f] + "]"
+	}
+	return "*k[" + "b-e-a-d-g-c-f-"[:-2*f] + "]"
+}
+
+func kernClef(clef byte) string {
+	switch clef {
+	case 1:
+		return "*clefF4"
+	case 2:
+		return "*clefC3"
+	case 3:
+		return "*clefC4"
+	case 4:
+		return "*clefG^2"
+	case 5:
+		return "*clefGv2"
+	}
+	return "*clefG2"
+}
+
+// kernBar returns the measure line for a barline from
+// convertBarType. Dotted barlines have no **kern equivalent and are
+// written as normal ones.
+func kernBar(barType string, number int) string {
+	s := fmt.Sprintf("=%d", number)
+	switch barType {
+	case "|:":
+		return s + "!|:"
+	case ":|":
+		return s + ":|!"
+	case ":|:":
+		return s + ":|!|:"
+	case "|.":
+		return s + "|!"
+	case "||":
+		return s + "||"
+	}
+	if number == 1 {
+		s += "-"
+	}
+	return s
+}
+
+// kernStaff is a spine, with a subspine for each voice.
+type kernStaff struct {
+	staff  int
+	voices []*kernVoice
+}
+
+// ConvertKern returns the records of a **kern score for data. Each
+// staff is a spine, with the lowest staff on the left as is usual in
+// Humdrum. Staves with more than one voice are split into subspines
+// for the whole piece.
+func ConvertKern(data *encore.Data, diag *Diagnostics) [][]string {
+	total := 0
+	if n := len(data.Measures); n > 0 {
+		last := data.Measures[n-1]
+		total = last.AbsTick + int(last.DurTicks)
+	}
+
+	staves, keys := voices(data)
+	byStaff := map[int]*kernStaff{}
+	for _, k := range keys {
+		ks := byStaff[k.staff]
+		if ks == nil {
+			ks = &kernStaff{staff: k.staff}
+			byStaff[k.staff] = ks
+		}
+		v := newKernVoice(data.Measures, len(ks.voices) == 0)
+		walkVoice(staves[k], v, diag)
+		v.fill(v.next, total, true)
+		ks.voices = append(ks.voices, v)
+	}
+	var spines []*kernStaff
+	for s := len(data.Staff) - 1; s >= 0; s-- {
+		ks := byStaff[s]
+		if ks == nil {
+			v := newKernVoice(data.Measures, true)
+			v.fill(0, total, false)
+			ks = &kernStaff{staff: s, voices: []*kernVoice{v}}
+		}
+		spines = append(spines, ks)
+	}
+
+	var records [][]string
+	// perStaff adds a record with a token per spine.
+	perStaff := func(fn func(ks *kernStaff) string) {
+		var r []string
+		for _, ks := range spines {
+			r = append(r, fn(ks))
+		}
+		records = append(records, r)
+	}
+	// perVoice adds a record with a token per subspine.
+	perVoice := func(fn func(ks *kernStaff, v *kernVoice) string) {
+		var r []string
+		for _, ks := range spines {
+			for _, v := range ks.voices {
+				r = append(r, fn(ks, v))
+			}
+		}
+		records = append(records, r)
+	}
+
+	perStaff(func(*kernStaff) string { return "**kern" })
+	perStaff(func(ks *kernStaff) string { return fmt.Sprintf("*staff%d", ks.staff+1) })
+	perStaff(func(ks *kernStaff) string {
+		if name := data.Staff[ks.staff].DisplayName(); name != "" {
+			return `*I"` + name
+		}
+		return "*"
+	})
+	for _, interp := range []func(v *kernVoice) string{
+		func(v *kernVoice) string { return v.initClef },
+		func(v *kernVoice) string { return v.initKey },
+	} {
+		perStaff(func(ks *kernStaff) string {
+			if s := interp(ks.voices[0]); s != "" {
+				return s
+			}
+			return "*"
+		})
+	}
+	bpm := 0
+	if len(data.Measures) > 0 {
+		m := data.Measures[0]
+		perStaff(func(*kernStaff) string { return fmt.Sprintf("*M%d/%d", m.TimeSigNum, m.TimeSigDen) })
+		if m.Bpm > 0 {
+			bpm = int(m.Bpm)
+			perStaff(func(*kernStaff) string { return fmt.Sprintf("*MM%d", bpm) })
+		}
+	}
+
+	maxVoices := 0
+	for _, ks := range spines {
+		if len(ks.voices) > maxVoices {
+			maxVoices = len(ks.voices)
+		}
+	}
+	for n := 1; n < maxVoices; n++ {
+		var r []string
+		for _, ks := range spines {
+			cols := n
+			if cols > len(ks.voices) {
+				cols = len(ks.voices)
+			}
+			for c := 0; c < cols; c++ {
+				if c == n-1 && n < len(ks.voices) {
+					r = append(r, "*^")
+				} else {
+					r = append(r, "*")
+				}
+			}
+		}
+		records = append(records, r)
+	}
+
+	for i, m := range data.Measures {
+		var prevEnd byte
+		if i > 0 {
+			prevEnd = data.Measures[i-1].BarTypeEnd
+		}
+		bar := kernBar(convertBarType(prevEnd, m.BarTypeStart), i+1)
+		perVoice(func(*kernStaff, *kernVoice) string { return bar })
+		if i > 0 && data.Measures[i-1].TimeSignature() != m.TimeSignature() {
+			perVoice(func(*kernStaff, *kernVoice) string {
+				return fmt.Sprintf("*M%d/%d", m.TimeSigNum, m.TimeSigDen)
+			})
+		}
+		if m.Bpm > 0 && int(m.Bpm) != bpm {
+			bpm = int(m.Bpm)
+			perVoice(func(*kernStaff, *kernVoice) string { return fmt.Sprintf("*MM%d", bpm) })
+		}
+
+		// Interpretations of the first voice apply to the
+		// whole staff.
+		interps := map[*kernStaff]map[int][]string{}
+		notes := map[*kernVoice]map[int][]string{}
+		ticks := map[int]bool{}
+		for _, ks := range spines {
+			interps[ks] = map[int][]string{}
+			for _, v := range ks.voices {
+				notes[v] = map[int][]string{}
+				for _, t := range v.tokens {
+					if t.measure != m.Id {
+						continue
+					}
+					ticks[t.tick] = true
+					if t.interp != "" {
+						interps[ks][t.tick] = append(interps[ks][t.tick], t.interp)
+					} else {
+						notes[v][t.tick] = append(notes[v][t.tick], t.String())
+					}
+				}
+			}
+		}
+		var sorted []int
+		for t := range ticks {
+			sorted = append(sorted, t)
+		}
+		sort.Ints(sorted)
+		for _, tick := range sorted {
+			n := 0
+			for _, ks := range spines {
+				if l := len(interps[ks][tick]); l > n {
+					n = l
+				}
+			}
+			for j := 0; j < n; j++ {
+				perVoice(func(ks *kernStaff, v *kernVoice) string {
+					if l := interps[ks][tick]; j < len(l) {
+						return l[j]
+					}
+					return "*"
+				})
+			}
+
+			n = 0
+			for _, l := range notes {
+				if len(l[tick]) > n {
+					n = len(l[tick])
+				}
+			}
+			for j := 0; j < n; j++ {
+				perVoice(func(ks *kernStaff, v *kernVoice) string {
+					if l := notes[v][tick]; j < len(l) {
+						return l[j]
+					}
+					return "."
+				})
+			}
+		}
+	}
+
+	final := "=="
+	if n := len(data.Measures); n > 0 && convertBarType(data.Measures[n-1].BarTypeEnd, 0) == ":|" {
+		final = "=:|!"
+	}
+	perVoice(func(*kernStaff, *kernVoice) string { return final })
+	for n := maxVoices; n > 1; n-- {
+		var r []string
+		for _, ks := range spines {
+			cols := n
+			if cols > len(ks.voices) {
+				cols = len(ks.voices)
+			}
+			for c := 0; c < cols; c++ {
+				if cols == n && c >= n-2 {
+					r = append(r, "*v")
+				} else {
+					r = append(r, "*")
+				}
+			}
+		}
+		records = append(records, r)
+	}
+	perStaff(func(*kernStaff) string { return "*-" })
+	return records
+}
+
+// WriteKern writes data as a Humdrum **kern file.
+func WriteKern(w io.Writer, data *encore.Data, diag *Diagnostics) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range ConvertKern(data, diag) {
+		fmt.Fprintln(bw, strings.Join(r, "\t"))
+	}
+	return bw.Flush()
+}
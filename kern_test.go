@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertKern(t *testing.T) {
+	d := testTwoVoices(t)
+
+	// The second staff comes first; the first staff splits for
+	// its second voice.
+	want := []string{
+		"**kern\t**kern",
+		"*staff2\t*staff1",
+		`*I"Staff 2` + "\t" + `*I"Staff 1`,
+		"*clefG2\t*clefG2",
+		"*k[]\t*k[]",
+		"*M4/4\t*M4/4",
+		"*MM120\t*MM120",
+		"*\t*^",
+		"=1-\t=1-\t=1-",
+		"1ryy\t2g\t1c",
+		".\t12d\t.",
+		".\t12e\t.",
+		".\t12f\t.",
+		".\t[4g\t.",
+		"=2:|!|:\t=2:|!|:\t=2:|!|:",
+		"1c\t1g]\t1c",
+		"=:|!\t=:|!\t=:|!",
+		"*\t*v\t*v",
+		"*-\t*-",
+	}
+	var got []string
+	for _, r := range ConvertKern(d, nil) {
+		got = append(got, strings.Join(r, "\t"))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestKernKey(t *testing.T) {
+	for key, want := range map[byte]string{
+		0:   "*k[]",
+		2:   "*k[b-e-]",
+		9:   "*k[f#c#]",
+		14:  "*k[f#c#g#d#a#e#b#]",
+		200: "*k[f#c#g#d#a#e#b#]",
+	} {
+		if got := kernKey(key); got != want {
+			t.Errorf("kernKey(%d): got %s, want %s", key, got, want)
+		}
+	}
+}
@@ -88,7 +88,7 @@ func (p *Pitch) String() string {
 	altsuffix := []string{"eses", "es", "", "is", "isis"}
 	alt := p.Alteration
 	if alt < -2 || alt > 2 {
-		log.Printf("illegal alteration %d", alt)
+		// Not expressible; callers should check.
 		alt = 0
 	}
 	n := names[p.Notename]
@@ -228,3 +228,18 @@ type PropertySet struct {
 func (p *PropertySet) String() string {
 	return fmt.Sprintf("\\set %s.%s = #%s", p.Context, p.Name, p.Value)
 }
+
+// Quote returns s as a LilyPond string.
+func Quote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return "\"" + s + "\""
+}
+
+type Markup struct {
+	Text string
+}
+
+func (m *Markup) String() string {
+	return fmt.Sprintf("\\markup %s\n", Quote(m.Text))
+}
@@ -29,3 +29,12 @@ func TestNote(t *testing.T) {
 		t.Errorf("got %s want %s", got, want)
 	}
 }
+
+func TestMarkup(t *testing.T) {
+	m := Markup{Text: `Sonata "Pathetique"`}
+	got := m.String()
+	want := "\\markup \"Sonata \\\"Pathetique\\\"\"\n"
+	if got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
@@ -1,29 +1,177 @@
 package main
 
 import (
+	"bytes"
 	"flag"
-	"io/ioutil"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/hanwen/go-enc2ly/encore"
+	"github.com/hanwen/go-enc2ly/midi"
 )
 
+// commands are run as "go-enc2ly [flags] <command> <args>".
+var commands = map[string]func(args []string){
+	"verify":   verify,
+	"dump":     dump,
+	"inspect":  inspect,
+	"diff":     diff,
+	"mutate":   mutate,
+	"coverage": coverage,
+	"stats":    stats,
+}
+
+// formats are the output formats for conversion.
+var formats = map[string]func(w io.Writer, d *encore.Data, diag *Diagnostics) error{
+	"ly": func(w io.Writer, d *encore.Data, diag *Diagnostics) error {
+		Convert(w, d, diag)
+		return nil
+	},
+	"midi": func(w io.Writer, d *encore.Data, diag *Diagnostics) error {
+		return midi.Write(w, d, func(e *encore.MeasElem, reason string) {
+			diag.Add(Error, e, "%s; skipped", reason)
+		})
+	},
+	"musicxml": WriteMusicXML,
+	"kern":     WriteKern,
+	"abc":      WriteABC,
+	"mei":      WriteMEI,
+}
+
+// modes are the names for the encore.Mode values.
+var modes = map[string]encore.Mode{
+	"normal":  encore.Normal,
+	"strict":  encore.Strict,
+	"lenient": encore.Lenient,
+}
+
+// decodeOptions are the decoding options from the flags. The
+// commands use them too.
+var decodeOptions = &encore.DecodeOptions{}
+
+// ConvertOptions controls conversion.
+type ConvertOptions struct {
+	// Mode is the mode the data was decoded with. In Strict mode,
+	// any diagnostic fails the conversion.
+	Mode encore.Mode
+}
+
+// convert converts d with write. It returns the output and the
+// diagnostics, including the anomalies found while decoding d.
+func convert(d *encore.Data, write func(io.Writer, *encore.Data, *Diagnostics) error, opts *ConvertOptions) ([]byte, *Diagnostics, error) {
+	diag := &Diagnostics{}
+	for _, pe := range d.Anomalies {
+		diag.AddParseError(Warning, pe)
+	}
+	var buf bytes.Buffer
+	if err := write(&buf, d, diag); err != nil {
+		return nil, diag, err
+	}
+	if opts.Mode == encore.Strict && len(diag.Entries) > 0 {
+		return nil, diag, fmt.Errorf("strict mode: %v", diag.Entries[0])
+	}
+	return buf.Bytes(), diag, nil
+}
+
+func formatNames() string {
+	var names []string
+	for k := range formats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 func main() {
 	debug := flag.Bool("debug", false, "debug")
+	maxSize := flag.Int64("max_size", 0, "maximum input size in bytes; 0 is unlimited")
+	format := flag.String("format", "ly", "output format: "+formatNames())
+	output := flag.String("o", "", "output file; default is stdout")
+	diagnostics := flag.String("diagnostics", "summary", "conversion problems on stderr: summary, json or none")
+	modeName := flag.String("mode", "normal", "handling of unexpected input: normal, strict (fail on any problem) or lenient (skip bad data)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] file.enc\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] verify file.enc...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] dump file.enc...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] inspect file.enc selector\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] diff a.enc b.enc\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] mutate [-delta N] [-o prefix] file.enc selector N[-M]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] coverage dir...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] stats [-json] file.enc...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "selector is one of %s\n", selectorHelp)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	content, err := ioutil.ReadFile(flag.Arg(0))
+	mode, ok := modes[*modeName]
+	if !ok {
+		log.Fatalf("unknown -mode %q, want normal, strict or lenient", *modeName)
+	}
+	decodeOptions = &encore.DecodeOptions{
+		MaxSize: *maxSize,
+		KeepRaw: *debug,
+		Mode:    mode,
+	}
+	if cmd := commands[flag.Arg(0)]; cmd != nil && flag.NArg() > 1 {
+		cmd(flag.Args()[1:])
+		return
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	write := formats[*format]
+	if write == nil {
+		log.Fatalf("unknown format %q, want one of %s", *format, formatNames())
+	}
+	switch *diagnostics {
+	case "summary", "json", "none":
+	default:
+		log.Fatalf("unknown -diagnostics %q, want summary, json or none", *diagnostics)
+	}
+
+	f, err := os.Open(flag.Arg(0))
 	if err != nil {
-		log.Fatal("ReadFile", err)
+		log.Fatal("Open", err)
 	}
+	defer f.Close()
 
-	d, err := encore.ReadData(content)
+	d, err := encore.Decode(f, decodeOptions)
 	if err != nil {
-		log.Fatalf("readData %v", err)
+		log.Fatalf("Decode %v", err)
 	}
 
 	if *debug {
 		analyze(d)
-	} else {
-		Convert(d)
+		return
+	}
+
+	result, diag, err := convert(d, write, &ConvertOptions{Mode: mode})
+	switch *diagnostics {
+	case "summary":
+		diag.WriteSummary(os.Stderr)
+	case "json":
+		if err := diag.WriteJSON(os.Stderr); err != nil {
+			log.Fatalf("diagnostics: %v", err)
+		}
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", *format, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		out, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Create %v", err)
+		}
+		defer out.Close()
+		w = out
+	}
+	if _, err := w.Write(result); err != nil {
+		log.Fatalf("Write %v", err)
 	}
 }
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/encore"
+	"github.com/hanwen/go-enc2ly/lily"
+	"github.com/hanwen/go-enc2ly/mei"
+)
+
+// meiID returns the xml:id for the data at byte offset off of the
+// source file.
+func meiID(off int) string {
+	return fmt.Sprintf("o%d", off)
+}
+
+// meiLayer is the music of a voice in one measure.
+type meiLayer struct {
+	layer *mei.Layer
+
+	// pos is the number of ticks from the start of the measure.
+	pos int
+
+	accid accidentals
+}
+
+// meiKey is a key signature from tick on.
+type meiKey struct {
+	tick   int
+	fifths int
+}
+
+// meiVoice collects the layers of a voice. Bar lines and meter
+// changes are handled in ConvertMEI.
+type meiVoice struct {
+	n      int
+	layers map[int]*meiLayer
+	diag   *Diagnostics
+
+	initKey  *meiKey
+	initClef *mei.Clef
+
+	// keys has the key changes of the staff.
+	keys []meiKey
+
+	// music is where notes are added: a layer or a tuplet.
+	music  *[]interface{}
+	beam   *encore.Beam
+	tuplet *mei.Tuplet
+
+	// last has the notes at the last tick and their pitches, and
+	// lastIdx the index of the first of them in lastMusic, which
+	// is in lastLayer.
+	last      []*mei.Note
+	lastPitch []int
+	lastMusic *[]interface{}
+	lastIdx   int
+	lastLayer *meiLayer
+
+	// tied has the pitches of notes with an open tie.
+	tied map[int]bool
+}
+
+func newMEIVoice(voice int, diag *Diagnostics) *meiVoice {
+	return &meiVoice{
+		n:      voice + 1,
+		layers: map[int]*meiLayer{},
+		diag:   diag,
+		tied:   map[int]bool{},
+	}
+}
+
+// at returns the layer of e, with spaces up to the tick of e, and
+// sets v.music.
+func (v *meiVoice) at(e *encore.MeasElem) *meiLayer {
+	l := v.layers[e.Measure.Id]
+	if l == nil {
+		l = &meiLayer{layer: &mei.Layer{N: v.n}, accid: accidentals{}}
+		v.layers[e.Measure.Id] = l
+	}
+	if v.tuplet == nil {
+		v.music = &l.layer.Music
+	}
+	if t := e.GetTick(); t > l.pos {
+		durs, rest := meiTicks(t - l.pos)
+		for _, d := range durs {
+			*v.music = append(*v.music, &mei.Space{Dur: d})
+		}
+		if rest > 0 {
+			v.diag.Add(Warning, e, "dropped %d ticks of space, shorter than a 64th", rest)
+		}
+		l.pos = t
+	}
+	return l
+}
+
+func (v *meiVoice) startMeasure(e *encore.MeasElem, prev *encore.Measure) {}
+func (v *meiVoice) barCheck()                                             {}
+func (v *meiVoice) timeSignature(m *encore.Measure)                       {}
+func (v *meiVoice) skip(ticks int)                                        {}
+
+func (v *meiVoice) key(e *encore.MeasElem, key byte) {
+	if v.initKey == nil {
+		v.initKey = &meiKey{fifths: keyFifths(key)}
+		return
+	}
+	v.at(e)
+	*v.music = append(*v.music, &mei.KeySig{Sig: meiKeySig(keyFifths(key))})
+}
+
+// fifths returns the key signature of the staff at tick.
+func (v *meiVoice) fifths(tick int) int {
+	f := 0
+	if v.initKey != nil {
+		f = v.initKey.fifths
+	}
+	for _, k := range v.keys {
+		if k.tick <= tick {
+			f = k.fifths
+		}
+	}
+	return f
+}
+
+// accid returns the accidental to write for p, given the key and the
+// earlier accidentals in l.
+func (v *meiVoice) accid(e *encore.MeasElem, l *meiLayer, p lily.Pitch) string {
+	if !l.accid.add(v.fifths(e.AbsTick()), p.Notename, p.Octave, p.Alteration) {
+		return ""
+	}
+	switch p.Alteration {
+	case 0:
+		return "n"
+	case 2:
+		return "x"
+	}
+	if p.Alteration > 0 {
+		return strings.Repeat("s", p.Alteration)
+	}
+	return strings.Repeat("f", -p.Alteration)
+}
+
+func (v *meiVoice) clef(e *encore.MeasElem, clef byte) {
+	if v.initClef == nil {
+		v.initClef = meiClef(clef)
+		return
+	}
+	v.at(e)
+	*v.music = append(*v.music, meiClef(clef))
+}
+
+func (v *meiVoice) tupletStart(e *encore.MeasElem, b *encore.Beam) {
+	v.at(e)
+	v.beam = b
+	v.tuplet = &mei.Tuplet{}
+	*v.music = append(*v.music, v.tuplet)
+	v.music = &v.tuplet.Music
+}
+
+func (v *meiVoice) tupletEnd() {
+	v.tuplet = nil
+	v.music = nil
+}
+
+func (v *meiVoice) setTuplet(w *encore.WithDuration) {
+	if v.tuplet != nil && v.tuplet.Num == 0 {
+		v.tuplet.Num, v.tuplet.Numbase = tupletRatio(v.beam, w)
+	}
+}
+
+func (v *meiVoice) tie(e *encore.MeasElem) {
+	for i, n := range v.last {
+		if n.Tie == "t" {
+			n.Tie = "m"
+		} else {
+			n.Tie = "i"
+		}
+		v.tied[v.lastPitch[i]] = true
+	}
+}
+
+func (v *meiVoice) note(e *encore.MeasElem, n *encore.Note, clef byte, chord bool) {
+	p, d := convertNote(n, basePitch(clef))
+	p.Normalize()
+	mn := &mei.Note{
+		ID:    meiID(e.Offset),
+		Pname: string("cdefgab"[p.Notename]),
+		Oct:   p.Octave + 4,
+	}
+	s := p.SemitonePitch()
+	if v.tied[s] {
+		mn.Tie = "t"
+		delete(v.tied, s)
+	}
+
+	// A note at the same tick in another measure, as after an
+	// overfull measure, does not join the chord.
+	if chord && len(v.last) > 0 && v.lastLayer == v.layers[e.Measure.Id] {
+		mn.Accid = v.accid(e, v.lastLayer, p)
+		if len(v.last) == 1 {
+			first := v.last[0]
+			c := &mei.Chord{Dur: first.Dur, Dots: first.Dots, Notes: []*mei.Note{first}}
+			first.Dur, first.Dots = "", 0
+			(*v.lastMusic)[v.lastIdx] = c
+		}
+		c := (*v.lastMusic)[v.lastIdx].(*mei.Chord)
+		c.Notes = append(c.Notes, mn)
+		v.last = append(v.last, mn)
+		v.lastPitch = append(v.lastPitch, s)
+		return
+	}
+
+	l := v.at(e)
+	v.setTuplet(&n.WithDuration)
+	mn.Accid = v.accid(e, l, p)
+	mn.Dur, mn.Dots = meiDur(d), d.Dots
+	v.last = []*mei.Note{mn}
+	v.lastPitch = []int{s}
+	v.lastMusic = v.music
+	v.lastIdx = len(*v.music)
+	v.lastLayer = l
+	*v.music = append(*v.music, mn)
+	l.pos += e.GetDurationTick()
+}
+
+func (v *meiVoice) rest(e *encore.MeasElem, r *encore.Rest) {
+	l := v.at(e)
+	v.setTuplet(&r.WithDuration)
+	d := convertRest(r)
+	*v.music = append(*v.music, &mei.Rest{ID: meiID(e.Offset), Dur: meiDur(d), Dots: d.Dots})
+	l.pos += e.GetDurationTick()
+	v.last = nil
+}
+
+func meiDur(d lily.Duration) string {
+	switch {
+	case d.DurationLog == -1:
+		return "breve"
+	case d.DurationLog == -2:
+		return "long"
+	case d.DurationLog < 0:
+		return "1"
+	}
+	return fmt.Sprint(1 << uint(d.DurationLog))
+}
+
+// meiTicks splits a number of ticks into plain durations, longest
+// first, and returns the remainder shorter than a 64th.
+func meiTicks(ticks int) (durs []string, rest int) {
+	for d := 1; d <= 64; d *= 2 {
+		for t := 4 * 240 / d; ticks >= t; ticks -= t {
+			durs = append(durs, fmt.Sprint(d))
+		}
+	}
+	return durs, ticks
+}
+
+func meiKeySig(f int) string {
+	switch {
+	case f > 0:
+		return fmt.Sprintf("%ds", f)
+	case f < 0:
+		return fmt.Sprintf("%df", -f)
+	}
+	return "0"
+}
+
+func meiClef(clef byte) *mei.Clef {
+	switch clef {
+	case 1:
+		return &mei.Clef{Shape: "F", Line: 4}
+	case 2:
+		return &mei.Clef{Shape: "C", Line: 3}
+	case 3:
+		return &mei.Clef{Shape: "C", Line: 4}
+	case 4:
+		return &mei.Clef{Shape: "G", Line: 2, Dis: 8, DisPlace: "above"}
+	case 5:
+		return &mei.Clef{Shape: "G", Line: 2, Dis: 8, DisPlace: "below"}
+	}
+	return &mei.Clef{Shape: "G", Line: 2}
+}
+
+// meiBarLines returns the left and right bar line attributes of m,
+// following convertBarType.
+func meiBarLines(m *encore.Measure) (left, right string) {
+	switch m.BarTypeStart {
+	case 2:
+		left = "rptstart"
+	case 1:
+		left = "end"
+	case 3:
+		left = "dbl"
+	case 8:
+		left = "dotted"
+	}
+	if m.BarTypeEnd == 4 {
+		right = "rptend"
+	}
+	return left, right
+}
+
+func convertStaffDef(n int, s *encore.Staff, first *meiVoice) *mei.StaffDef {
+	sd := &mei.StaffDef{
+		ID:    meiID(s.Offset),
+		N:     n,
+		Label: s.DisplayName(),
+		Lines: 5,
+	}
+	if sd.Label != "" {
+		sd.LabelAbbr = shortName(sd.Label)
+	}
+	clef := &mei.Clef{Shape: "G", Line: 2}
+	if first != nil {
+		if first.initKey != nil {
+			sd.KeySig = meiKeySig(first.initKey.fifths)
+		}
+		if first.initClef != nil {
+			clef = first.initClef
+		}
+	}
+	sd.ClefShape, sd.ClefLine = clef.Shape, clef.Line
+	sd.ClefDis, sd.ClefDisPlace = clef.Dis, clef.DisPlace
+	return sd
+}
+
+// ConvertMEI returns an MEI score for data. Staves, measures, notes
+// and rests have an xml:id with their byte offset in the source
+// file, eg. "o1234".
+func ConvertMEI(data *encore.Data, diag *Diagnostics) *mei.MEI {
+	doc := &mei.MEI{Version: "5.0"}
+	// The title is required.
+	doc.Head.Titles = []mei.Title{{}}
+
+	staves, keys := voices(data)
+	staffKeys := map[int][]meiKey{}
+	for _, k := range keys {
+		for _, e := range staves[k] {
+			if kc, ok := e.TypeSpecific.(*encore.KeyChange); ok && e.Problem() == "" {
+				staffKeys[k.staff] = append(staffKeys[k.staff], meiKey{e.AbsTick(), keyFifths(kc.NewKey)})
+			}
+		}
+	}
+	for _, ks := range staffKeys {
+		sort.SliceStable(ks, func(i, j int) bool { return ks[i].tick < ks[j].tick })
+	}
+	staffVoices := map[int][]*meiVoice{}
+	for _, k := range keys {
+		v := newMEIVoice(k.voice, diag)
+		v.keys = staffKeys[k.staff]
+		walkVoice(staves[k], v, diag)
+		staffVoices[k.staff] = append(staffVoices[k.staff], v)
+	}
+
+	score := &doc.Score
+	grp := &mei.StaffGrp{}
+	for i, s := range data.Staff {
+		var first *meiVoice
+		if vs := staffVoices[i]; len(vs) > 0 {
+			first = vs[0]
+		}
+		grp.StaffDefs = append(grp.StaffDefs, convertStaffDef(i+1, s, first))
+	}
+	score.ScoreDef.StaffGrp = grp
+	if len(data.Measures) > 0 {
+		score.ScoreDef.MeterCount = int(data.Measures[0].TimeSigNum)
+		score.ScoreDef.MeterUnit = int(data.Measures[0].TimeSigDen)
+	}
+
+	var ending *mei.Ending
+	for i, m := range data.Measures {
+		music := &score.Section.Music
+		if m.RepeatAlternative == 0 {
+			ending = nil
+		} else {
+			if ending == nil || ending.N != fmt.Sprint(m.RepeatAlternative) {
+				ending = &mei.Ending{N: fmt.Sprint(m.RepeatAlternative)}
+				*music = append(*music, ending)
+			}
+			music = &ending.Music
+		}
+		if i > 0 && data.Measures[i-1].TimeSignature() != m.TimeSignature() {
+			*music = append(*music, &mei.ScoreDef{
+				MeterCount: int(m.TimeSigNum),
+				MeterUnit:  int(m.TimeSigDen),
+			})
+		}
+
+		mm := &mei.Measure{ID: meiID(m.Offset), N: i + 1}
+		mm.Left, mm.Right = meiBarLines(m)
+		for s := range data.Staff {
+			st := &mei.Staff{N: s + 1}
+			for _, v := range staffVoices[s] {
+				if l := v.layers[m.Id]; l != nil {
+					st.Layers = append(st.Layers, l.layer)
+				}
+			}
+			if len(st.Layers) == 0 {
+				st.Layers = append(st.Layers, &mei.Layer{N: 1, Music: []interface{}{&mei.MRest{}}})
+			}
+			mm.Staffs = append(mm.Staffs, st)
+		}
+		*music = append(*music, mm)
+	}
+	return doc
+}
+
+// WriteMEI writes data as an MEI 5 document.
+func WriteMEI(w io.Writer, data *encore.Data, diag *Diagnostics) error {
+	return mei.Write(w, ConvertMEI(data, diag))
+}
@@ -0,0 +1,171 @@
+// Package mei has types for writing MEI 5 scores. Only the elements
+// needed for conversion from Encore are present.
+package mei
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+type MEI struct {
+	XMLName xml.Name `xml:"http://www.music-encoding.org/ns/mei mei"`
+	Version string   `xml:"meiversion,attr"`
+	Head    Head     `xml:"meiHead"`
+	Score   Score    `xml:"music>body>mdiv>score"`
+}
+
+type Head struct {
+	Titles  []Title  `xml:"fileDesc>titleStmt>title"`
+	PubStmt struct{} `xml:"fileDesc>pubStmt"`
+}
+
+type Title struct {
+	Text string `xml:",chardata"`
+}
+
+type Score struct {
+	ScoreDef ScoreDef `xml:"scoreDef"`
+	Section  Section  `xml:"section"`
+}
+
+// ScoreDef sets the meter for the following measures. The first
+// ScoreDef of a score also has the staves.
+type ScoreDef struct {
+	XMLName    xml.Name  `xml:"scoreDef"`
+	MeterCount int       `xml:"meter.count,attr,omitempty"`
+	MeterUnit  int       `xml:"meter.unit,attr,omitempty"`
+	StaffGrp   *StaffGrp `xml:"staffGrp"`
+}
+
+type StaffGrp struct {
+	StaffDefs []*StaffDef `xml:"staffDef"`
+}
+
+type StaffDef struct {
+	ID           string `xml:"http://www.w3.org/XML/1998/namespace id,attr,omitempty"`
+	N            int    `xml:"n,attr"`
+	Label        string `xml:"label,attr,omitempty"`
+	LabelAbbr    string `xml:"label.abbr,attr,omitempty"`
+	Lines        int    `xml:"lines,attr"`
+	ClefShape    string `xml:"clef.shape,attr,omitempty"`
+	ClefLine     int    `xml:"clef.line,attr,omitempty"`
+	ClefDis      int    `xml:"clef.dis,attr,omitempty"`
+	ClefDisPlace string `xml:"clef.dis.place,attr,omitempty"`
+	KeySig       string `xml:"keysig,attr,omitempty"`
+}
+
+// Section holds *Measure, *Ending and *ScoreDef.
+type Section struct {
+	Music []interface{}
+}
+
+func (s *Section) Append(e interface{}) {
+	s.Music = append(s.Music, e)
+}
+
+// Ending is a volta; it holds *Measure and *ScoreDef.
+type Ending struct {
+	XMLName xml.Name `xml:"ending"`
+	N       string   `xml:"n,attr"`
+	Music   []interface{}
+}
+
+// Measure has the staves of a measure. Left and Right are the bar
+// lines, eg. "rptstart", "rptend", "dbl", "end" or "dotted".
+type Measure struct {
+	XMLName xml.Name `xml:"measure"`
+	ID      string   `xml:"http://www.w3.org/XML/1998/namespace id,attr,omitempty"`
+	N       int      `xml:"n,attr"`
+	Left    string   `xml:"left,attr,omitempty"`
+	Right   string   `xml:"right,attr,omitempty"`
+	Staffs  []*Staff `xml:"staff"`
+}
+
+type Staff struct {
+	N      int      `xml:"n,attr"`
+	Layers []*Layer `xml:"layer"`
+}
+
+// Layer is a voice. Its Music has *Note, *Chord, *Rest, *MRest,
+// *Space, *Tuplet, *KeySig and *Clef.
+type Layer struct {
+	N     int `xml:"n,attr"`
+	Music []interface{}
+}
+
+// Note has a duration if it is not in a Chord. Tie is "i", "m" or
+// "t" for the start, middle or end of tied notes. Accid is the
+// written accidental, eg. "s", "n" or "ff".
+type Note struct {
+	XMLName xml.Name `xml:"note"`
+	ID      string   `xml:"http://www.w3.org/XML/1998/namespace id,attr,omitempty"`
+	Pname   string   `xml:"pname,attr"`
+	Oct     int      `xml:"oct,attr"`
+	Dur     string   `xml:"dur,attr,omitempty"`
+	Dots    int      `xml:"dots,attr,omitempty"`
+	Accid   string   `xml:"accid,attr,omitempty"`
+	Tie     string   `xml:"tie,attr,omitempty"`
+}
+
+type Chord struct {
+	XMLName xml.Name `xml:"chord"`
+	Dur     string   `xml:"dur,attr"`
+	Dots    int      `xml:"dots,attr,omitempty"`
+	Notes   []*Note  `xml:"note"`
+}
+
+type Rest struct {
+	XMLName xml.Name `xml:"rest"`
+	ID      string   `xml:"http://www.w3.org/XML/1998/namespace id,attr,omitempty"`
+	Dur     string   `xml:"dur,attr"`
+	Dots    int      `xml:"dots,attr,omitempty"`
+}
+
+// MRest is a rest for the whole measure.
+type MRest struct {
+	XMLName xml.Name `xml:"mRest"`
+}
+
+// Space is an invisible rest.
+type Space struct {
+	XMLName xml.Name `xml:"space"`
+	Dur     string   `xml:"dur,attr"`
+	Dots    int      `xml:"dots,attr,omitempty"`
+}
+
+// Tuplet plays Num notes in the time of Numbase. Its Music has the
+// same elements as a Layer.
+type Tuplet struct {
+	XMLName xml.Name `xml:"tuplet"`
+	Num     int      `xml:"num,attr"`
+	Numbase int      `xml:"numbase,attr"`
+	Music   []interface{}
+}
+
+// KeySig is a key change, eg. "2s" or "3f".
+type KeySig struct {
+	XMLName xml.Name `xml:"keySig"`
+	Sig     string   `xml:"sig,attr"`
+}
+
+type Clef struct {
+	XMLName  xml.Name `xml:"clef"`
+	Shape    string   `xml:"shape,attr"`
+	Line     int      `xml:"line,attr"`
+	Dis      int      `xml:"dis,attr,omitempty"`
+	DisPlace string   `xml:"dis.place,attr,omitempty"`
+}
+
+// Write writes m as an MEI document.
+func Write(w io.Writer, m *MEI) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
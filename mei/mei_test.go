@@ -0,0 +1,53 @@
+package mei
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	layer := &Layer{N: 1}
+	layer.Music = append(layer.Music,
+		&Chord{Dur: "4", Notes: []*Note{
+			{ID: "o100", Pname: "c", Oct: 4},
+			{ID: "o128", Pname: "e", Oct: 4, Accid: "f"},
+		}},
+		&Tuplet{Num: 3, Numbase: 2, Music: []interface{}{
+			&Rest{ID: "o156", Dur: "8"},
+		}},
+		&Space{Dur: "2"},
+	)
+	m := &MEI{Version: "5.0"}
+	m.Head.Titles = []Title{{Text: "Air"}}
+	m.Score.ScoreDef = ScoreDef{
+		MeterCount: 3,
+		MeterUnit:  4,
+		StaffGrp:   &StaffGrp{StaffDefs: []*StaffDef{{N: 1, Lines: 5, ClefShape: "G", ClefLine: 2}}},
+	}
+	m.Score.Section.Append(&Measure{ID: "o62", N: 1, Right: "rptend",
+		Staffs: []*Staff{{N: 1, Layers: []*Layer{layer}}}})
+
+	var buf bytes.Buffer
+	if err := Write(&buf, m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`<mei xmlns="http://www.music-encoding.org/ns/mei" meiversion="5.0">`,
+		"<title>Air</title>",
+		"<pubStmt></pubStmt>",
+		`<scoreDef meter.count="3" meter.unit="4">`,
+		`<measure xml:id="o62" n="1" right="rptend">`,
+		`<note xml:id="o128" pname="e" oct="4" accid="f"></note>`,
+		`<tuplet num="3" numbase="2">`,
+		`<space dur="2"></space>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "xmlns"); n != 1 {
+		t.Errorf("got %d namespace declarations, want 1:\n%s", n, got)
+	}
+}
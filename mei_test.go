@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/mei"
+)
+
+// meiSummary describes the music of a layer, eg. "c4/4 +e4" for a
+// chord, with "(3:2" and ")" around tuplets, "~i", "~m" and "~t" for
+// ties, "s/4" for spaces and "clef:F4" for clef changes.
+func meiSummary(music []interface{}) string {
+	note := func(n *mei.Note) string {
+		s := fmt.Sprintf("%s%s%d", n.Pname, n.Accid, n.Oct)
+		if n.Dur != "" {
+			s += "/" + n.Dur
+		}
+		if n.Tie != "" {
+			s += "~" + n.Tie
+		}
+		return s
+	}
+	var s []string
+	for _, e := range music {
+		switch t := e.(type) {
+		case *mei.Note:
+			s = append(s, note(t))
+		case *mei.Chord:
+			for i, n := range t.Notes {
+				w := note(n)
+				if i == 0 {
+					w += "/" + t.Dur
+				} else {
+					w = "+" + w
+				}
+				s = append(s, w)
+			}
+		case *mei.Rest:
+			s = append(s, "r/"+t.Dur)
+		case *mei.MRest:
+			s = append(s, "mrest")
+		case *mei.Space:
+			s = append(s, "s/"+t.Dur)
+		case *mei.Tuplet:
+			s = append(s, fmt.Sprintf("(%d:%d %s)", t.Num, t.Numbase, meiSummary(t.Music)))
+		case *mei.KeySig:
+			s = append(s, "key:"+t.Sig)
+		case *mei.Clef:
+			s = append(s, fmt.Sprintf("clef:%s%d", t.Shape, t.Line))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func TestConvertMEI(t *testing.T) {
+	var diag Diagnostics
+	doc := ConvertMEI(testMusic(t), &diag)
+	if len(diag.Entries) > 0 {
+		t.Errorf("got diagnostics %v", diag.Entries)
+	}
+	want := [][]string{
+		{
+			"c4/4 +e4 (3:2 d4/8 e4/8 f4/8) r/4 g4/4~i",
+			"g4/4~t r/2 r/4",
+		},
+		{
+			"c4/2 clef:F4 c3/2",
+			"g2/1",
+		},
+	}
+	var ms []*mei.Measure
+	for _, e := range doc.Score.Section.Music {
+		if m, ok := e.(*mei.Measure); ok {
+			ms = append(ms, m)
+		}
+	}
+	if len(ms) != 2 {
+		t.Fatalf("got %d measures, want 2", len(ms))
+	}
+	for i, m := range ms {
+		for j, st := range m.Staffs {
+			var got []string
+			for _, l := range st.Layers {
+				got = append(got, meiSummary(l.Music))
+			}
+			if g := strings.Join(got, " | "); g != want[j][i] {
+				t.Errorf("measure %d staff %d:\ngot  %s\nwant %s", i, j, g, want[j][i])
+			}
+		}
+	}
+	for i, sd := range doc.Score.ScoreDef.StaffGrp.StaffDefs {
+		if sd.ClefShape != "G" || sd.ClefLine != 2 {
+			t.Errorf("staff %d: got clef %s%d, want G2", i, sd.ClefShape, sd.ClefLine)
+		}
+	}
+}
+
+func TestConvertMEIVoices(t *testing.T) {
+	doc := ConvertMEI(testTwoVoices(t), nil)
+	var got []string
+	for _, e := range doc.Score.Section.Music {
+		m := e.(*mei.Measure)
+		var layers []string
+		for _, l := range m.Staffs[0].Layers {
+			layers = append(layers, fmt.Sprintf("%d: %s", l.N, meiSummary(l.Music)))
+		}
+		got = append(got, fmt.Sprintf("%s %s %s", m.Left, strings.Join(layers, ", "), m.Right))
+	}
+	want := []string{
+		" 1: g4/2 (3:2 d4/8 e4/8 f4/8) g4/4~i, 2: c4/1 rptend",
+		"rptstart 1: g4/1~t, 2: c4/1 rptend",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestConvertMEISpaceRemainder(t *testing.T) {
+	d := testScore(t, 1, 1,
+		testElem{0, 250, 0, 0, testNote(3, 4, 67)},
+	)
+	var diag Diagnostics
+	doc := ConvertMEI(d, &diag)
+	m := doc.Score.Section.Music[0].(*mei.Measure)
+	if got, want := meiSummary(m.Staffs[0].Layers[0].Music), "s/4 g4/4"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if len(diag.Entries) != 1 {
+		t.Fatalf("got diagnostics %v, want 1", diag.Entries)
+	}
+	e := diag.Entries[0]
+	if e.Severity != Warning || e.Tick != 250 || !strings.Contains(e.Message, "dropped 10 ticks") {
+		t.Errorf("got %v", e)
+	}
+}
+
+func TestConvertMEIOverfull(t *testing.T) {
+	// The first note ends the first measure at the tick where the
+	// second measure starts.
+	d := testScore(t, 1, 2,
+		testElem{0, 960, 0, 0, testNote(3, 4, 67)},
+		testElem{1, 0, 0, 0, testNote(3, 5, 69)},
+	)
+	doc := ConvertMEI(d, nil)
+	var got []string
+	for _, e := range doc.Score.Section.Music {
+		got = append(got, meiSummary(e.(*mei.Measure).Staffs[0].Layers[0].Music))
+	}
+	want := []string{"s/1 g4/4", "a4/4"}
+	if strings.Join(got, " | ") != strings.Join(want, " | ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
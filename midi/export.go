@@ -0,0 +1,177 @@
+package midi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// PPQ is the MIDI timebase. Encore uses 60 ticks per 16th note, ie.
+// 240 per quarter, so ticks are used unchanged.
+const PPQ = 240
+
+// note is a sounding note on one staff.
+type note struct {
+	tick, dur int
+	channel   int
+	key       int
+	velocity  int
+	voice     int
+	tied      bool
+}
+
+// Write writes d as a MIDI file, with a conductor track holding the
+// tempo and time signatures, and one track per staff. skipped is as
+// for Convert.
+func Write(w io.Writer, d *encore.Data, skipped func(e *encore.MeasElem, reason string)) error {
+	f, err := Convert(d, skipped)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// Convert converts d to a MIDI file. Elements that cannot be played
+// are passed to skipped, if it is not nil, with the reason.
+func Convert(d *encore.Data, skipped func(e *encore.MeasElem, reason string)) (*File, error) {
+	skip := func(e *encore.MeasElem, reason string) {
+		if skipped != nil {
+			skipped(e, reason)
+		}
+	}
+
+	f := &File{PPQ: PPQ}
+	conductor := &Track{}
+	f.Tracks = append(f.Tracks, conductor)
+
+	type tieKey struct {
+		staff, voice, tick int
+	}
+	ties := map[tieKey]bool{}
+	notes := make([][]*note, len(d.Staff))
+	bpm := 0
+	timeSig := ""
+	for i := 0; i < d.NumMeasures(); i++ {
+		m, err := d.Measure(i)
+		if err != nil {
+			return nil, err
+		}
+		if m.Bpm > 0 && int(m.Bpm) != bpm {
+			bpm = int(m.Bpm)
+			conductor.Tempo(m.AbsTick, bpm)
+		}
+		if m.TimeSigDen > 0 && m.TimeSignature() != timeSig {
+			timeSig = m.TimeSignature()
+			conductor.TimeSignature(m.AbsTick, int(m.TimeSigNum), int(m.TimeSigDen))
+		}
+
+		for _, e := range m.Elems {
+			staff := e.GetStaff()
+			if staff >= len(d.Staff) {
+				skip(e, fmt.Sprintf("staff %d out of range", staff))
+				continue
+			}
+			if p := e.Problem(); p != "" {
+				skip(e, p)
+				continue
+			}
+			switch t := e.TypeSpecific.(type) {
+			case *encore.Tie:
+				// The tie starts from the note at the same tick.
+				ties[tieKey{staff, e.Voice(), e.AbsTick()}] = true
+			case *encore.Note:
+				notes[staff] = append(notes[staff], convertNote(e, t))
+			}
+		}
+	}
+
+	for i, ns := range notes {
+		for _, n := range ns {
+			n.tied = ties[tieKey{i, n.voice, n.tick}]
+		}
+		sort.SliceStable(ns, func(a, b int) bool {
+			return ns[a].tick < ns[b].tick
+		})
+	}
+
+	for i, s := range d.Staff {
+		t := &Track{}
+		if name := s.DisplayName(); name != "" {
+			t.Name(name)
+		}
+		done := map[int]bool{}
+		for v := 0; v < 8; v++ {
+			ch := s.Channel(v)
+			if done[ch] {
+				continue
+			}
+			done[ch] = true
+			t.Add(0, 0xc0|byte(ch), byte(s.Program(v)))
+			if vol := s.Volume(v); vol > 0 {
+				t.Add(0, 0xb0|byte(ch), 7, byte(vol))
+			}
+		}
+		for _, n := range mergeTies(notes[i]) {
+			t.Add(n.tick, 0x90|byte(n.channel), byte(n.key), byte(n.velocity))
+			t.Add(n.tick+n.dur, 0x80|byte(n.channel), byte(n.key), 0)
+		}
+		f.Tracks = append(f.Tracks, t)
+	}
+	return f, nil
+}
+
+func convertNote(e *encore.MeasElem, n *encore.Note) *note {
+	key := int(n.SemitonePitch) + int(e.Staff.Transposition)
+	if key < 0 {
+		key = 0
+	} else if key > 127 {
+		key = 127
+	}
+	vel := int(n.Velocity & 0x7f)
+	if vel == 0 {
+		vel = 64
+	}
+	dur := int(n.PlaybackDurationTicks)
+	if dur == 0 {
+		dur = n.GetDurationTick()
+	}
+	return &note{
+		tick:     e.AbsTick(),
+		dur:      dur,
+		channel:  e.Staff.Channel(e.Voice()),
+		key:      key,
+		velocity: vel,
+		voice:    e.Voice(),
+	}
+}
+
+// mergeTies extends tied notes by the note of the same pitch in the
+// same voice that starts where the tied note ends, which is then
+// dropped. A tie without such a note is ignored.
+func mergeTies(ns []*note) []*note {
+	var result []*note
+	drop := map[*note]bool{}
+	for i, n := range ns {
+		if drop[n] {
+			continue
+		}
+		result = append(result, n)
+		cur := n
+		for j := i + 1; cur.tied && j < len(ns); j++ {
+			next := ns[j]
+			if next.voice != n.voice || next.key != n.key || next.tick < cur.tick+cur.dur || drop[next] {
+				continue
+			}
+			if next.tick > cur.tick+cur.dur {
+				break
+			}
+			n.dur = next.tick + next.dur - n.tick
+			drop[next] = true
+			cur = next
+		}
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+// Package midi writes Standard MIDI Files.
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// Event is a MIDI or meta event at an absolute tick.
+type Event struct {
+	Tick int
+	Data []byte
+}
+
+// Track is a sequence of events. The events need not be sorted.
+type Track struct {
+	Events []Event
+}
+
+func (t *Track) Add(tick int, data ...byte) {
+	t.Events = append(t.Events, Event{Tick: tick, Data: data})
+}
+
+// Meta adds a meta event of type typ.
+func (t *Track) Meta(tick int, typ byte, data []byte) {
+	ev := []byte{0xff, typ}
+	ev = append(ev, vlq(len(data))...)
+	t.Add(tick, append(ev, data...)...)
+}
+
+// minBpm is the lowest tempo whose microseconds per beat fit in the
+// 24 bits of the tempo event.
+const minBpm = 4
+
+// Tempo adds a tempo change in beats per minute. Tempos below minBpm
+// are written as minBpm.
+func (t *Track) Tempo(tick int, bpm int) {
+	if bpm < minBpm {
+		bpm = minBpm
+	}
+	us := 60000000 / bpm
+	t.Meta(tick, 0x51, []byte{byte(us >> 16), byte(us >> 8), byte(us)})
+}
+
+// TimeSignature adds a time signature num/den.
+func (t *Track) TimeSignature(tick int, num, den int) {
+	l := 0
+	for 1<<uint(l) < den {
+		l++
+	}
+	t.Meta(tick, 0x58, []byte{byte(num), byte(l), 24, 8})
+}
+
+// Name adds a track name.
+func (t *Track) Name(name string) {
+	t.Meta(0, 0x03, []byte(name))
+}
+
+// vlq returns n as a variable length quantity.
+func vlq(n int) []byte {
+	b := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		b = append([]byte{byte(n&0x7f) | 0x80}, b...)
+	}
+	return b
+}
+
+func (e *Event) isNoteOn() bool {
+	return e.Data[0]&0xf0 == 0x90
+}
+
+// encode returns the MTrk chunk for t. At the same tick, note-on
+// events come last, so a note ending there does not cut off a new
+// one; other events keep the order in which they were added.
+func (t *Track) encode() []byte {
+	evs := append([]Event(nil), t.Events...)
+	sort.SliceStable(evs, func(i, j int) bool {
+		if evs[i].Tick != evs[j].Tick {
+			return evs[i].Tick < evs[j].Tick
+		}
+		return !evs[i].isNoteOn() && evs[j].isNoteOn()
+	})
+
+	var body []byte
+	last := 0
+	for _, e := range evs {
+		body = append(body, vlq(e.Tick-last)...)
+		body = append(body, e.Data...)
+		last = e.Tick
+	}
+	body = append(body, 0, 0xff, 0x2f, 0)
+
+	chunk := []byte("MTrk\x00\x00\x00\x00")
+	binary.BigEndian.PutUint32(chunk[4:], uint32(len(body)))
+	return append(chunk, body...)
+}
+
+// File is a format 1 MIDI file.
+type File struct {
+	// Ticks per quarter note.
+	PPQ    int
+	Tracks []*Track
+}
+
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString("MThd")
+	binary.Write(&buf, binary.BigEndian, []uint32{6})
+	binary.Write(&buf, binary.BigEndian, []uint16{1, uint16(len(f.Tracks)), uint16(f.PPQ)})
+	for _, t := range f.Tracks {
+		buf.Write(t.encode())
+	}
+	return buf.WriteTo(w)
+}
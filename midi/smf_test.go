@@ -0,0 +1,131 @@
+package midi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestVLQ(t *testing.T) {
+	for n, want := range map[int][]byte{
+		0:        {0},
+		0x7f:     {0x7f},
+		0x80:     {0x81, 0},
+		0x3fff:   {0xff, 0x7f},
+		0x200000: {0x81, 0x80, 0x80, 0},
+	} {
+		if got := vlq(n); !bytes.Equal(got, want) {
+			t.Errorf("vlq(%d) = %x, want %x", n, got, want)
+		}
+	}
+}
+
+func TestTempo(t *testing.T) {
+	for bpm, want := range map[int][]byte{
+		120: {0x07, 0xa1, 0x20},
+		4:   {0xe4, 0xe1, 0xc0},
+		3:   {0xe4, 0xe1, 0xc0},
+		0:   {0xe4, 0xe1, 0xc0},
+		-1:  {0xe4, 0xe1, 0xc0},
+	} {
+		var tr Track
+		tr.Tempo(0, bpm)
+		if got := tr.Events[0].Data[3:]; !bytes.Equal(got, want) {
+			t.Errorf("Tempo(%d) = %x, want %x", bpm, got, want)
+		}
+	}
+}
+
+func elem(m *encore.Measure, s *encore.Staff, tick uint16, typ byte, spec encore.MeasElemSpecific) *encore.MeasElem {
+	e := &encore.MeasElem{
+		Tick:         tick,
+		TypeVoice:    typ << 4,
+		TypeSpecific: spec,
+		Measure:      m,
+		Staff:        s,
+	}
+	m.Elems = append(m.Elems, e)
+	return e
+}
+
+func TestConvert(t *testing.T) {
+	s := &encore.Staff{Transposition: -2}
+	s.MidiChannel[0] = 3
+	s.MidiVolume[0] = 100
+	d := &encore.Data{Staff: []*encore.Staff{s}}
+	for i := 0; i < 2; i++ {
+		d.Measures = append(d.Measures, &encore.Measure{
+			Bpm: 120, DurTicks: 960, TimeSigNum: 4, TimeSigDen: 4, AbsTick: 960 * i,
+		})
+	}
+	elem(d.Measures[0], s, 0, encore.TYPE_NOTE, &encore.Note{SemitonePitch: 62, PlaybackDurationTicks: 960})
+	elem(d.Measures[0], s, 0, encore.TYPE_TIE, &encore.Tie{})
+	elem(d.Measures[1], s, 0, encore.TYPE_NOTE, &encore.Note{SemitonePitch: 62, PlaybackDurationTicks: 900})
+
+	f, err := Convert(d, nil)
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if len(f.Tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(f.Tracks))
+	}
+	// Only voice 0 has a volume; the other voices share channel 0.
+	var volumes []Event
+	for _, e := range f.Tracks[1].Events {
+		if e.Data[0]&0xf0 == 0xb0 {
+			volumes = append(volumes, e)
+		}
+	}
+	if len(volumes) != 1 || !bytes.Equal(volumes[0].Data, []byte{0xb3, 7, 100}) {
+		t.Errorf("got volume events %v, want only channel 3", volumes)
+	}
+	var got []Event
+	for _, e := range f.Tracks[1].Events {
+		if e.Data[0]&0xe0 == 0x80 {
+			got = append(got, e)
+		}
+	}
+	want := []Event{
+		{0, []byte{0x93, 60, 64}},
+		{960 + 900, []byte{0x83, 60, 0}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Tick != want[i].Tick || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Errorf("event %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if want := "MThd\x00\x00\x00\x06\x00\x01\x00\x02\x00\xf0"; !bytes.HasPrefix(buf.Bytes(), []byte(want)) {
+		t.Errorf("got header %q, want %q", buf.Bytes()[:14], want)
+	}
+}
+
+func TestMergeTies(t *testing.T) {
+	ns := []*note{
+		{tick: 0, dur: 240, key: 60, tied: true},
+		// A note overlapping the tied one, and another voice.
+		{tick: 120, dur: 240, key: 60},
+		{tick: 240, dur: 240, key: 60, voice: 1},
+		{tick: 240, dur: 240, key: 60, tied: true},
+		// Not adjacent, so the second tie is ignored.
+		{tick: 720, dur: 240, key: 60},
+	}
+	got := mergeTies(ns)
+	if len(got) != 4 {
+		t.Fatalf("got %d notes, want 4", len(got))
+	}
+	if got[0] != ns[0] || got[0].dur != 480 {
+		t.Errorf("got first note %+v, want duration 480", got[0])
+	}
+	if got[3] != ns[4] || got[3].dur != 240 {
+		t.Errorf("got last note %+v, want it unchanged", got[3])
+	}
+}
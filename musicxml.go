@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/hanwen/go-enc2ly/encore"
+	"github.com/hanwen/go-enc2ly/lily"
+	"github.com/hanwen/go-enc2ly/musicxml"
+)
+
+// Durations are written in ticks, of which there are 240 per quarter.
+const xmlDivisions = 240
+
+// xmlMeasure is the music of a voice in one measure.
+type xmlMeasure struct {
+	// attrs are the attributes at the start of the measure.
+	attrs *musicxml.Attributes
+	music []interface{}
+
+	// pos is the number of ticks from the start of the measure.
+	pos int
+}
+
+// xmlVoice collects the notes of a voice per measure. Barlines and
+// time signatures do not depend on the voice and are handled in
+// ConvertMusicXML.
+type xmlVoice struct {
+	voice string
+
+	// attributes is set if key and clef changes should be written.
+	attributes bool
+
+	measures map[int]*xmlMeasure
+
+	tuplet     *encore.Beam
+	newTuplet  bool
+	tupletLast *musicxml.Note
+
+	lastChord []*musicxml.Note
+
+	// tied has the pitches of notes with an open tie.
+	tied map[int]bool
+}
+
+func newXMLVoice(voice int, attributes bool) *xmlVoice {
+	return &xmlVoice{
+		voice:      strconv.Itoa(voice + 1),
+		attributes: attributes,
+		measures:   map[int]*xmlMeasure{},
+		tied:       map[int]bool{},
+	}
+}
+
+// at returns the measure of e, moved forward to the tick of e.
+func (v *xmlVoice) at(e *encore.MeasElem) *xmlMeasure {
+	m := v.measures[e.Measure.Id]
+	if m == nil {
+		m = &xmlMeasure{}
+		v.measures[e.Measure.Id] = m
+	}
+	if t := e.GetTick(); t > m.pos {
+		m.music = append(m.music, &musicxml.Forward{Duration: t - m.pos, Voice: v.voice})
+		m.pos = t
+	}
+	return m
+}
+
+func (v *xmlVoice) startMeasure(e *encore.MeasElem, prev *encore.Measure) {}
+func (v *xmlVoice) barCheck()                                             {}
+func (v *xmlVoice) timeSignature(m *encore.Measure)                       {}
+func (v *xmlVoice) skip(ticks int)                                        {}
+
+// setAttributes adds the attributes set by fn at e.
+func (v *xmlVoice) setAttributes(e *encore.MeasElem, fn func(a *musicxml.Attributes)) {
+	if !v.attributes {
+		return
+	}
+	m := v.at(e)
+	if m.pos == 0 && len(m.music) == 0 {
+		if m.attrs == nil {
+			m.attrs = &musicxml.Attributes{}
+		}
+		fn(m.attrs)
+		return
+	}
+	a := &musicxml.Attributes{}
+	fn(a)
+	m.music = append(m.music, a)
+}
+
+func (v *xmlVoice) key(e *encore.MeasElem, key byte) {
+	v.setAttributes(e, func(a *musicxml.Attributes) {
+		a.Key = &musicxml.Key{Fifths: keyFifths(key), Mode: "major"}
+	})
+}
+
+func (v *xmlVoice) clef(e *encore.MeasElem, clef byte) {
+	v.setAttributes(e, func(a *musicxml.Attributes) {
+		a.Clef = xmlClef(clef)
+	})
+}
+
+func (v *xmlVoice) tupletStart(e *encore.MeasElem, b *encore.Beam) {
+	v.tuplet = b
+	v.newTuplet = true
+}
+
+func (v *xmlVoice) tupletEnd() {
+	if v.tupletLast != nil {
+		not := addNotations(v.tupletLast)
+		not.Tuplets = append(not.Tuplets, musicxml.Tuplet{Type: "stop"})
+	}
+	v.tuplet = nil
+	v.tupletLast = nil
+}
+
+func (v *xmlVoice) tie(e *encore.MeasElem) {
+	for _, n := range v.lastChord {
+		n.Ties = append(n.Ties, musicxml.Tie{Type: "start"})
+		not := addNotations(n)
+		not.Tied = append(not.Tied, musicxml.Tied{Type: "start"})
+		v.tied[xmlSemitone(n.Pitch)] = true
+	}
+}
+
+// addTuplet sets the time modification of a note or rest in a
+// tuplet.
+func (v *xmlVoice) addTuplet(n *musicxml.Note, w *encore.WithDuration) {
+	if v.tuplet == nil {
+		return
+	}
+	actual, normal := tupletRatio(v.tuplet, w)
+	n.TimeModification = &musicxml.TimeModification{ActualNotes: actual, NormalNotes: normal}
+	if n.Chord != nil {
+		return
+	}
+	if v.newTuplet {
+		not := addNotations(n)
+		not.Tuplets = append(not.Tuplets, musicxml.Tuplet{Type: "start"})
+		v.newTuplet = false
+	}
+	v.tupletLast = n
+}
+
+func (v *xmlVoice) note(e *encore.MeasElem, n *encore.Note, clef byte, chord bool) {
+	p, d := convertNote(n, basePitch(clef))
+	xn := &musicxml.Note{
+		Pitch:    xmlPitch(p),
+		Duration: e.GetDurationTick(),
+		Voice:    v.voice,
+		Type:     xmlType(d.DurationLog),
+		Dots:     make([]musicxml.Empty, d.Dots),
+	}
+	if s := xmlSemitone(xn.Pitch); v.tied[s] {
+		xn.Ties = append(xn.Ties, musicxml.Tie{Type: "stop"})
+		addNotations(xn).Tied = []musicxml.Tied{{Type: "stop"}}
+		delete(v.tied, s)
+	}
+
+	m := v.measures[e.Measure.Id]
+	if chord && len(v.lastChord) > 0 && m != nil {
+		xn.Chord = &musicxml.Empty{}
+		v.lastChord = append(v.lastChord, xn)
+	} else {
+		m = v.at(e)
+		m.pos += xn.Duration
+		v.lastChord = []*musicxml.Note{xn}
+	}
+	v.addTuplet(xn, &n.WithDuration)
+	m.music = append(m.music, xn)
+}
+
+func (v *xmlVoice) rest(e *encore.MeasElem, r *encore.Rest) {
+	d := convertRest(r)
+	xn := &musicxml.Note{
+		Rest:     &musicxml.Rest{},
+		Duration: e.GetDurationTick(),
+		Voice:    v.voice,
+		Type:     xmlType(d.DurationLog),
+		Dots:     make([]musicxml.Empty, d.Dots),
+	}
+	m := v.at(e)
+	m.pos += xn.Duration
+	v.lastChord = nil
+	v.addTuplet(xn, &r.WithDuration)
+	m.music = append(m.music, xn)
+}
+
+func addNotations(n *musicxml.Note) *musicxml.Notations {
+	if n.Notations == nil {
+		n.Notations = &musicxml.Notations{}
+	}
+	return n.Notations
+}
+
+func xmlPitch(p lily.Pitch) *musicxml.Pitch {
+	p.Normalize()
+	return &musicxml.Pitch{
+		Step:   string("CDEFGAB"[p.Notename]),
+		Alter:  p.Alteration,
+		Octave: p.Octave + 4,
+	}
+}
+
+// xmlSemitone returns the MIDI pitch of p.
+func xmlSemitone(p *musicxml.Pitch) int {
+	scale := map[string]int{"C": 0, "D": 2, "E": 4, "F": 5, "G": 7, "A": 9, "B": 11}
+	return 12*(p.Octave+1) + scale[p.Step] + p.Alter
+}
+
+func xmlType(durationLog int) string {
+	names := []string{"whole", "half", "quarter", "eighth", "16th",
+		"32nd", "64th", "128th", "256th", "512th", "1024th"}
+	switch {
+	case durationLog == -1:
+		return "breve"
+	case durationLog == -2:
+		return "long"
+	case durationLog >= 0 && durationLog < len(names):
+		return names[durationLog]
+	}
+	return ""
+}
+
+// keyFifths returns the number of sharps (positive) or flats
+// (negative) for an Encore key, see convertKey.
+func keyFifths(key byte) int {
+	if key <= 7 {
+		return -int(key)
+	}
+	return int(key) - 7
+}
+
+func xmlClef(clef byte) *musicxml.Clef {
+	switch clef {
+	case 1:
+		return &musicxml.Clef{Sign: "F", Line: 4}
+	case 2:
+		return &musicxml.Clef{Sign: "C", Line: 3}
+	case 3:
+		return &musicxml.Clef{Sign: "C", Line: 4}
+	case 4:
+		return &musicxml.Clef{Sign: "G", Line: 2, OctaveChange: 1}
+	case 5:
+		return &musicxml.Clef{Sign: "G", Line: 2, OctaveChange: -1}
+	}
+	return &musicxml.Clef{Sign: "G", Line: 2}
+}
+
+// xmlBarlines returns the barlines at the start and end of measure i,
+// following convertBarType, and the volta brackets.
+func xmlBarlines(measures []*encore.Measure, i int) (left, right *musicxml.Barline) {
+	m := measures[i]
+	left = &musicxml.Barline{Location: "left"}
+	switch m.BarTypeStart {
+	case 2:
+		left.BarStyle = "heavy-light"
+		left.Repeat = &musicxml.Repeat{Direction: "forward"}
+	case 1:
+		left.BarStyle = "light-heavy"
+	case 3:
+		left.BarStyle = "light-light"
+	case 8:
+		left.BarStyle = "dotted"
+	}
+	right = &musicxml.Barline{Location: "right"}
+	if m.BarTypeEnd == 4 {
+		right.BarStyle = "light-heavy"
+		right.Repeat = &musicxml.Repeat{Direction: "backward"}
+	}
+
+	if volta := m.RepeatAlternative; volta > 0 {
+		num := strconv.Itoa(int(volta))
+		if i == 0 || measures[i-1].RepeatAlternative != volta {
+			left.Ending = &musicxml.Ending{Number: num, Type: "start", Text: num + "."}
+		}
+		if i == len(measures)-1 || measures[i+1].RepeatAlternative != volta {
+			right.Ending = &musicxml.Ending{Number: num, Type: "discontinue"}
+			if right.Repeat != nil {
+				right.Ending.Type = "stop"
+			}
+		}
+	}
+
+	if left.BarStyle == "" && left.Ending == nil {
+		left = nil
+	}
+	if right.BarStyle == "" && right.Ending == nil {
+		right = nil
+	}
+	return left, right
+}
+
+func convertScorePart(id string, s *encore.Staff) *musicxml.ScorePart {
+	p := &musicxml.ScorePart{ID: id, Name: s.DisplayName()}
+	if p.Name != "" {
+		p.Abbreviation = shortName(p.Name)
+	}
+	p.ScoreInstrument = &musicxml.ScoreInstrument{
+		ID:   id + "-I1",
+		Name: lily.MidiInstruments[s.Program(0)],
+	}
+	p.MidiInstrument = &musicxml.MidiInstrument{
+		ID:      id + "-I1",
+		Channel: s.Channel(0) + 1,
+		Program: s.Program(0) + 1,
+	}
+	if vol := s.Volume(0); vol > 0 {
+		p.MidiInstrument.Volume = float64(vol*1000/127) / 10
+	}
+	return p
+}
+
+// ConvertMusicXML returns a MusicXML score for data, with a part per
+// staff.
+func ConvertMusicXML(data *encore.Data, diag *Diagnostics) *musicxml.ScorePartwise {
+	score := &musicxml.ScorePartwise{
+		Version: "4.0",
+	}
+	for _, t := range data.Text {
+		score.Credits = append(score.Credits, &musicxml.Credit{Page: 1, Words: t})
+	}
+
+	staves, keys := voices(data)
+	staffVoices := map[int][]*xmlVoice{}
+	for _, k := range keys {
+		v := newXMLVoice(k.voice, len(staffVoices[k.staff]) == 0)
+		walkVoice(staves[k], v, diag)
+		staffVoices[k.staff] = append(staffVoices[k.staff], v)
+	}
+
+	for s, staff := range data.Staff {
+		id := fmt.Sprintf("P%d", s+1)
+		score.PartList.ScoreParts = append(score.PartList.ScoreParts, convertScorePart(id, staff))
+		part := &musicxml.Part{ID: id}
+		for i, m := range data.Measures {
+			xm := &musicxml.Measure{Number: strconv.Itoa(i + 1)}
+			left, right := xmlBarlines(data.Measures, i)
+			if left != nil {
+				xm.Append(left)
+			}
+
+			attrs := &musicxml.Attributes{}
+			if i == 0 {
+				attrs.Divisions = xmlDivisions
+			}
+			if i == 0 || data.Measures[i-1].TimeSignature() != m.TimeSignature() {
+				attrs.Time = &musicxml.Time{Beats: int(m.TimeSigNum), BeatType: int(m.TimeSigDen)}
+			}
+			if vs := staffVoices[s]; len(vs) > 0 {
+				if a := vs[0].measures[m.Id]; a != nil && a.attrs != nil {
+					attrs.Key = a.attrs.Key
+					attrs.Clef = a.attrs.Clef
+				}
+			}
+			if attrs.Divisions != 0 || attrs.Time != nil || attrs.Key != nil || attrs.Clef != nil {
+				xm.Append(attrs)
+			}
+
+			pos := 0
+			filled := false
+			for _, v := range staffVoices[s] {
+				vm := v.measures[m.Id]
+				if vm == nil {
+					continue
+				}
+				if pos > 0 {
+					xm.Append(&musicxml.Backup{Duration: pos})
+				}
+				xm.Music = append(xm.Music, vm.music...)
+				pos = vm.pos
+				filled = filled || pos > 0
+			}
+			if !filled {
+				xm.Append(&musicxml.Note{
+					Rest:     &musicxml.Rest{Measure: "yes"},
+					Duration: int(m.DurTicks),
+					Voice:    "1",
+				})
+			}
+
+			if right != nil {
+				xm.Append(right)
+			}
+			part.Measures = append(part.Measures, xm)
+		}
+		score.Parts = append(score.Parts, part)
+	}
+	return score
+}
+
+// WriteMusicXML writes data as a MusicXML 4.0 partwise score.
+func WriteMusicXML(w io.Writer, data *encore.Data, diag *Diagnostics) error {
+	return musicxml.Write(w, ConvertMusicXML(data, diag))
+}
@@ -0,0 +1,190 @@
+// Package musicxml has types for writing MusicXML 4.0 partwise scores.
+// Only the elements needed for conversion from Encore are present.
+package musicxml
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+const doctype = `<!DOCTYPE score-partwise PUBLIC "-//Recordare//DTD MusicXML 4.0 Partwise//EN" "http://www.musicxml.org/dtds/partwise.dtd">` + "\n"
+
+type ScorePartwise struct {
+	XMLName  xml.Name  `xml:"score-partwise"`
+	Version  string    `xml:"version,attr"`
+	Credits  []*Credit `xml:"credit"`
+	PartList PartList  `xml:"part-list"`
+	Parts    []*Part   `xml:"part"`
+}
+
+// Credit is text printed on the first page.
+type Credit struct {
+	Page  int    `xml:"page,attr"`
+	Words string `xml:"credit-words"`
+}
+
+type PartList struct {
+	ScoreParts []*ScorePart `xml:"score-part"`
+}
+
+type ScorePart struct {
+	ID              string           `xml:"id,attr"`
+	Name            string           `xml:"part-name"`
+	Abbreviation    string           `xml:"part-abbreviation,omitempty"`
+	ScoreInstrument *ScoreInstrument `xml:"score-instrument"`
+	MidiInstrument  *MidiInstrument  `xml:"midi-instrument"`
+}
+
+type ScoreInstrument struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"instrument-name"`
+}
+
+// MidiInstrument has 1-based channel and program numbers, and a
+// volume from 0 to 100. A zero volume is left out.
+type MidiInstrument struct {
+	ID      string  `xml:"id,attr"`
+	Channel int     `xml:"midi-channel"`
+	Program int     `xml:"midi-program"`
+	Volume  float64 `xml:"volume,omitempty"`
+}
+
+type Part struct {
+	ID       string     `xml:"id,attr"`
+	Measures []*Measure `xml:"measure"`
+}
+
+// Measure holds the music of a part in one measure. The elements of
+// Music are *Attributes, *Note, *Backup, *Forward and *Barline.
+type Measure struct {
+	Number string `xml:"number,attr"`
+	Music  []interface{}
+}
+
+func (m *Measure) Append(e interface{}) {
+	m.Music = append(m.Music, e)
+}
+
+// Empty is an element without content, eg. <chord/>.
+type Empty struct{}
+
+type Attributes struct {
+	XMLName   xml.Name `xml:"attributes"`
+	Divisions int      `xml:"divisions,omitempty"`
+	Key       *Key     `xml:"key"`
+	Time      *Time    `xml:"time"`
+	Clef      *Clef    `xml:"clef"`
+}
+
+type Key struct {
+	Fifths int    `xml:"fifths"`
+	Mode   string `xml:"mode,omitempty"`
+}
+
+type Time struct {
+	Beats    int `xml:"beats"`
+	BeatType int `xml:"beat-type"`
+}
+
+type Clef struct {
+	Sign         string `xml:"sign"`
+	Line         int    `xml:"line,omitempty"`
+	OctaveChange int    `xml:"clef-octave-change,omitempty"`
+}
+
+type Note struct {
+	XMLName          xml.Name          `xml:"note"`
+	Chord            *Empty            `xml:"chord"`
+	Pitch            *Pitch            `xml:"pitch"`
+	Rest             *Rest             `xml:"rest"`
+	Duration         int               `xml:"duration"`
+	Ties             []Tie             `xml:"tie"`
+	Voice            string            `xml:"voice,omitempty"`
+	Type             string            `xml:"type,omitempty"`
+	Dots             []Empty           `xml:"dot"`
+	TimeModification *TimeModification `xml:"time-modification"`
+	Notations        *Notations        `xml:"notations"`
+}
+
+type Pitch struct {
+	Step   string `xml:"step"`
+	Alter  int    `xml:"alter,omitempty"`
+	Octave int    `xml:"octave"`
+}
+
+type Rest struct {
+	// Measure is "yes" for a rest filling the whole measure.
+	Measure string `xml:"measure,attr,omitempty"`
+}
+
+// Tie is "start" or "stop". It affects playback; the tie is drawn
+// with Notations.Tied.
+type Tie struct {
+	Type string `xml:"type,attr"`
+}
+
+type TimeModification struct {
+	ActualNotes int `xml:"actual-notes"`
+	NormalNotes int `xml:"normal-notes"`
+}
+
+type Notations struct {
+	Tied    []Tied   `xml:"tied"`
+	Tuplets []Tuplet `xml:"tuplet"`
+}
+
+type Tied struct {
+	Type string `xml:"type,attr"`
+}
+
+type Tuplet struct {
+	Type string `xml:"type,attr"`
+}
+
+// Backup moves back in time, to start another voice.
+type Backup struct {
+	XMLName  xml.Name `xml:"backup"`
+	Duration int      `xml:"duration"`
+}
+
+// Forward moves forward in time, over a gap in a voice.
+type Forward struct {
+	XMLName  xml.Name `xml:"forward"`
+	Duration int      `xml:"duration"`
+	Voice    string   `xml:"voice,omitempty"`
+}
+
+type Barline struct {
+	XMLName  xml.Name `xml:"barline"`
+	Location string   `xml:"location,attr"`
+	BarStyle string   `xml:"bar-style,omitempty"`
+	Ending   *Ending  `xml:"ending"`
+	Repeat   *Repeat  `xml:"repeat"`
+}
+
+// Ending is a volta bracket. Type is "start", "stop" or
+// "discontinue".
+type Ending struct {
+	Number string `xml:"number,attr"`
+	Type   string `xml:"type,attr"`
+	Text   string `xml:",chardata"`
+}
+
+// Repeat has Direction "forward" or "backward".
+type Repeat struct {
+	Direction string `xml:"direction,attr"`
+}
+
+// Write writes s as a MusicXML document.
+func Write(w io.Writer, s *ScorePartwise) error {
+	if _, err := io.WriteString(w, xml.Header+doctype); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,52 @@
+package musicxml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	m := &Measure{Number: "1"}
+	m.Append(&Attributes{Divisions: 240, Time: &Time{Beats: 3, BeatType: 4}})
+	m.Append(&Note{Pitch: &Pitch{Step: "C", Octave: 4}, Duration: 240, Type: "quarter"})
+	m.Append(&Note{Chord: &Empty{}, Pitch: &Pitch{Step: "E", Alter: -1, Octave: 4}, Duration: 240})
+	m.Append(&Backup{Duration: 240})
+	m.Append(&Note{Rest: &Rest{Measure: "yes"}, Duration: 720})
+	m.Append(&Barline{Location: "right", Repeat: &Repeat{Direction: "backward"}})
+	s := &ScorePartwise{
+		Version:  "4.0",
+		PartList: PartList{ScoreParts: []*ScorePart{{ID: "P1", Name: "Flute"}}},
+		Parts:    []*Part{{ID: "P1", Measures: []*Measure{m}}},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, s); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"<!DOCTYPE score-partwise PUBLIC",
+		`<score-partwise version="4.0">`,
+		"<divisions>240</divisions>",
+		"<alter>-1</alter>",
+		"<backup>",
+		`<rest measure="yes"></rest>`,
+		`<repeat direction="backward"></repeat>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "<identification>") || strings.Contains(got, "<Music>") {
+		t.Errorf("unexpected element in output:\n%s", got)
+	}
+	for _, order := range [][2]string{
+		{"<attributes>", "<barline"},
+		{"<chord>", "<step>E"},
+	} {
+		if i, j := strings.Index(got, order[0]), strings.Index(got, order[1]); i < 0 || i > j {
+			t.Errorf("%s not before %s:\n%s", order[0], order[1], got)
+		}
+	}
+}
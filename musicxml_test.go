@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+	"github.com/hanwen/go-enc2ly/musicxml"
+)
+
+// xmlSummary describes the music of a measure, eg. "C4/quarter
+// +E4/quarter" for a chord, with "(3:2" and ")" around tuplets, "~"
+// and "~|" for tie starts and stops, and "clef:F4" for clef changes.
+func xmlSummary(m *musicxml.Measure) string {
+	var s []string
+	for _, e := range m.Music {
+		switch t := e.(type) {
+		case *musicxml.Attributes:
+			if t.Clef != nil {
+				s = append(s, fmt.Sprintf("clef:%s%d", t.Clef.Sign, t.Clef.Line))
+			}
+		case *musicxml.Note:
+			w := "r"
+			if p := t.Pitch; p != nil {
+				w = fmt.Sprintf("%s%s%d", p.Step, strings.Repeat("#", p.Alter), p.Octave)
+			}
+			if t.Chord != nil {
+				w = "+" + w
+			}
+			w += "/" + t.Type
+			for _, tie := range t.Ties {
+				if tie.Type == "start" {
+					w += "~"
+				} else {
+					w = "~|" + w
+				}
+			}
+			if n := t.Notations; n != nil {
+				for _, tu := range n.Tuplets {
+					tm := t.TimeModification
+					if tu.Type == "start" {
+						w = fmt.Sprintf("(%d:%d %s", tm.ActualNotes, tm.NormalNotes, w)
+					} else {
+						w += ")"
+					}
+				}
+			}
+			s = append(s, w)
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func TestConvertMusicXML(t *testing.T) {
+	var diag Diagnostics
+	score := ConvertMusicXML(testMusic(t), &diag)
+	if len(diag.Entries) > 0 {
+		t.Errorf("got diagnostics %v", diag.Entries)
+	}
+	want := [][]string{
+		{
+			"clef:G2 C4/quarter +E4/quarter (3:2 D4/eighth E4/eighth F4/eighth) r/quarter G4/quarter~",
+			"~|G4/quarter r/half r/quarter",
+		},
+		{
+			"clef:G2 C4/half clef:F4 C3/half",
+			"G2/whole",
+		},
+	}
+	if len(score.Parts) != len(want) {
+		t.Fatalf("got %d parts, want %d", len(score.Parts), len(want))
+	}
+	for i, p := range score.Parts {
+		for j, m := range p.Measures {
+			if got := xmlSummary(m); got != want[i][j] {
+				t.Errorf("part %d measure %d:\ngot  %s\nwant %s", i, j, got, want[i][j])
+			}
+		}
+	}
+
+	// Tuplet notes take 2/3 of their face value.
+	n := score.Parts[0].Measures[0].Music[3].(*musicxml.Note)
+	if n.Duration != 80 {
+		t.Errorf("got tuplet note duration %d, want 80", n.Duration)
+	}
+}
+
+func TestConvertScorePart(t *testing.T) {
+	s := &encore.Staff{}
+	var buf bytes.Buffer
+	if err := xml.NewEncoder(&buf).Encode(convertScorePart("P1", s).MidiInstrument); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if strings.Contains(buf.String(), "volume") {
+		t.Errorf("got %s, want no volume", buf.String())
+	}
+
+	s.MidiVolume[0] = 127
+	if got := convertScorePart("P1", s).MidiInstrument.Volume; got != 100 {
+		t.Errorf("got volume %v, want 100", got)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// mutation is a manifest entry for one variant file.
+type mutation struct {
+	File string
+
+	// Byte is the offset relative to the start of each target.
+	Byte  int
+	Delta int
+
+	Changes []mutationChange
+
+	// ParseError is set if the variant no longer parses.
+	ParseError string `json:",omitempty"`
+
+	// Finding is left empty, for recording what Encore shows.
+	Finding string
+}
+
+type mutationChange struct {
+	Target   string
+	Offset   int
+	Location string
+	Old, New byte
+}
+
+// parseByteRange parses "N" or "N-M", both inclusive.
+func parseByteRange(s string) (from, to int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	from, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("byte range %q: %v", s, err)
+	}
+	to = from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("byte range %q: %v", s, err)
+		}
+	}
+	if from < 0 || to < from {
+		return 0, 0, fmt.Errorf("byte range %q is empty", s)
+	}
+	return from, to, nil
+}
+
+// mutations returns a variant of raw for each byte in [from, to],
+// with that byte of every target incremented by delta.
+func mutations(d *encore.Data, sels []*selection, from, to, delta int) ([][]byte, []*mutation) {
+	var variants [][]byte
+	var manifest []*mutation
+	for b := from; b <= to; b++ {
+		raw := make([]byte, len(d.Raw))
+		copy(raw, d.Raw)
+		m := &mutation{Byte: b, Delta: delta}
+		for _, s := range sels {
+			if b >= s.Size {
+				continue
+			}
+			off := s.Offset + b
+			raw[off] += byte(delta)
+			m.Changes = append(m.Changes, mutationChange{
+				Target:   s.Name,
+				Offset:   off,
+				Location: d.Locate(off).String(),
+				Old:      d.Raw[off],
+				New:      raw[off],
+			})
+		}
+		if len(m.Changes) == 0 {
+			continue
+		}
+		if _, err := encore.ReadData(raw); err != nil {
+			m.ParseError = err.Error()
+		}
+		variants = append(variants, raw)
+		manifest = append(manifest, m)
+	}
+	return variants, manifest
+}
+
+// mutate writes variants of an Encore file with bytes of the
+// selected blocks or elements changed, and a JSON manifest describing
+// each variant.
+func mutate(args []string) {
+	fs := flag.NewFlagSet("mutate", flag.ExitOnError)
+	delta := fs.Int("delta", 1, "amount to add to each byte")
+	prefix := fs.String("o", "mutate", "prefix for the variant files and manifest")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: mutate [flags] file.enc selector N[-M]\n")
+		fmt.Fprintf(os.Stderr, "selector is one of %s, or %s\n", selectorHelp, elemsHelp)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	d, err := readEnc(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("%s: %v", fs.Arg(0), err)
+	}
+	sels, rest, err := parseSelections(d, fs.Args()[1:])
+	if err == nil && len(rest) != 1 {
+		err = fmt.Errorf("want a byte range after the selector, got %q", rest)
+	}
+	if err != nil {
+		log.Fatalf("mutate: %v", err)
+	}
+	from, to, err := parseByteRange(rest[0])
+	if err != nil {
+		log.Fatalf("mutate: %v", err)
+	}
+
+	variants, manifest := mutations(d, sels, from, to, *delta)
+	for i, raw := range variants {
+		manifest[i].File = fmt.Sprintf("%s%d.enc", *prefix, i)
+		if err := ioutil.WriteFile(manifest[i].File, raw, 0644); err != nil {
+			log.Fatalf("WriteFile: %v", err)
+		}
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Fatalf("Marshal: %v", err)
+	}
+	if err := ioutil.WriteFile(*prefix+".json", append(content, '\n'), 0644); err != nil {
+		log.Fatalf("WriteFile: %v", err)
+	}
+	fmt.Printf("wrote %d variants of %d targets, manifest in %s.json\n", len(variants), len(sels), *prefix)
+}
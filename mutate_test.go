@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestMutations(t *testing.T) {
+	d := testScore(t, 1, 1,
+		testElem{0, 0, 0, 0, testRest(3)},
+		testElem{0, 240, 0, 0, testNote(3, 4, 67)},
+		testElem{0, 480, 0, 0, testRest(2)},
+	)
+	sels, rest, err := parseSelections(d, []string{"elems", "type", "rest", "5-6"})
+	if err != nil {
+		t.Fatalf("parseSelections: %v", err)
+	}
+	if len(sels) != 2 || len(rest) != 1 {
+		t.Fatalf("got %v, rest %q", sels, rest)
+	}
+	from, to, err := parseByteRange(rest[0])
+	if err != nil {
+		t.Fatalf("parseByteRange: %v", err)
+	}
+
+	variants, manifest := mutations(d, sels, from, to, 2)
+	if len(variants) != 2 || len(manifest) != 2 {
+		t.Fatalf("got %d variants, %d entries, want 2", len(variants), len(manifest))
+	}
+	elems := d.Measures[0].Elems
+	for i, m := range manifest {
+		if m.Byte != 5+i || m.Delta != 2 || m.ParseError != "" {
+			t.Errorf("entry %d: got %+v", i, m)
+		}
+		if len(m.Changes) != 2 {
+			t.Fatalf("entry %d: got changes %+v", i, m.Changes)
+		}
+		for j, e := range []int{0, 2} {
+			c := m.Changes[j]
+			off := elems[e].Offset + 5 + i
+			if c.Offset != off || c.Old != d.Raw[off] || c.New != d.Raw[off]+2 || variants[i][off] != c.New {
+				t.Errorf("entry %d change %d: got %+v", i, j, c)
+			}
+			if c.Location != d.Locate(off).String() {
+				t.Errorf("entry %d change %d: got location %q", i, j, c.Location)
+			}
+		}
+		// Only the targets change.
+		diffs := 0
+		for k := range d.Raw {
+			if variants[i][k] != d.Raw[k] {
+				diffs++
+			}
+		}
+		if diffs != 2 {
+			t.Errorf("entry %d: %d bytes changed, want 2", i, diffs)
+		}
+	}
+	if got, want := manifest[0].Changes[0].Location, "MEAS 0 elem 0 Rest byte 5 (FaceValue)"; got != want {
+		t.Errorf("got location %q, want %q", got, want)
+	}
+
+	// Bytes past the end of all targets give no variant.
+	sel, _, err := parseSelector(d, []string{"meas", "0", "elem", "1"})
+	if err != nil {
+		t.Fatalf("parseSelector: %v", err)
+	}
+	variants, manifest = mutations(d, []*selection{sel}, 27, 40, 1)
+	if len(variants) != 1 || manifest[0].Byte != 27 {
+		t.Errorf("got %d variants, want 1 for byte 27", len(variants))
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		from, to int
+		ok       bool
+	}{
+		{"3", 3, 3, true},
+		{"3-7", 3, 7, true},
+		{"7-3", 0, 0, false},
+		{"-1", 0, 0, false},
+		{"x", 0, 0, false},
+		{"1-y", 0, 0, false},
+	} {
+		from, to, err := parseByteRange(tc.in)
+		if (err == nil) != tc.ok || from != tc.from || to != tc.to {
+			t.Errorf("parseByteRange(%q): got %d, %d, %v", tc.in, from, to, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// selectorHelp describes the selectors accepted by parseSelector.
+const selectorHelp = `"header", "staff N", "page N", "line N", "line N staff M", "meas N", "meas N elem M" or "section N"`
+
+// elemsHelp describes the element filters accepted by
+// parseSelections.
+const elemsHelp = `"elems" followed by any of "meas N", "staff N", "voice N" and "type T"`
+
+// selection is a byte range of an Encore file, picked by a selector.
+type selection struct {
+	Name   string
+	Offset int
+	Size   int
+}
+
+func (s *selection) String() string {
+	return fmt.Sprintf("%s at offset %d, %d bytes", s.Name, s.Offset, s.Size)
+}
+
+// selectorIndex parses the index following keyword args[0].
+func selectorIndex(args []string, n int) (int, error) {
+	if len(args) < 2 {
+		return 0, fmt.Errorf("%s needs an index", args[0])
+	}
+	i, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", args[0], err)
+	}
+	if i < 0 || i >= n {
+		return 0, fmt.Errorf("%s %d out of range [0, %d)", args[0], i, n)
+	}
+	return i, nil
+}
+
+// parseSelector returns the part of d picked by the selector at the
+// start of args, and the remaining arguments.
+func parseSelector(d *encore.Data, args []string) (*selection, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("missing selector, want %s", selectorHelp)
+	}
+	switch args[0] {
+	case "header":
+		h := &d.Header
+		return &selection{"header", h.Offset, len(h.Raw)}, args[1:], nil
+	case "staff":
+		i, err := selectorIndex(args, len(d.Staff))
+		if err != nil {
+			return nil, nil, err
+		}
+		s := d.Staff[i]
+		return &selection{fmt.Sprintf("staff %d (%s)", i, s.DisplayName()), s.Offset, len(s.Raw)}, args[2:], nil
+	case "page":
+		i, err := selectorIndex(args, len(d.Pages))
+		if err != nil {
+			return nil, nil, err
+		}
+		p := d.Pages[i]
+		return &selection{fmt.Sprintf("page %d", i), p.Offset, len(p.Raw)}, args[2:], nil
+	case "line":
+		i, err := selectorIndex(args, len(d.Lines))
+		if err != nil {
+			return nil, nil, err
+		}
+		l := d.Lines[i]
+		args = args[2:]
+		if len(args) > 0 && args[0] == "staff" {
+			j, err := selectorIndex(args, len(l.Staffs))
+			if err != nil {
+				return nil, nil, err
+			}
+			off := l.Offset + len(l.Raw) + 26 + 30*j
+			return &selection{fmt.Sprintf("line %d staff %d", i, j), off, len(l.Staffs[j].Raw)}, args[2:], nil
+		}
+		return &selection{fmt.Sprintf("line %d", i), l.Offset, len(l.Raw) + len(l.VarData)}, args, nil
+	case "meas", "measure":
+		i, err := selectorIndex(args, len(d.Measures))
+		if err != nil {
+			return nil, nil, err
+		}
+		m := d.Measures[i]
+		args = args[2:]
+		if len(args) > 0 && args[0] == "elem" {
+			j, err := selectorIndex(args, len(m.Elems))
+			if err != nil {
+				return nil, nil, err
+			}
+			e := m.Elems[j]
+			name := fmt.Sprintf("meas %d elem %d", i, j)
+			if e.TypeSpecific != nil {
+				name += " (" + e.GetTypeName() + ")"
+			}
+			return &selection{name, e.Offset, len(e.Raw)}, args[2:], nil
+		}
+		return &selection{fmt.Sprintf("meas %d", i), m.Offset, len(m.Raw)}, args, nil
+	case "section":
+		i, err := selectorIndex(args, len(d.Extra))
+		if err != nil {
+			return nil, nil, err
+		}
+		s := d.Extra[i]
+		return &selection{fmt.Sprintf("section %d (%q)", i, s.Tag), s.Offset, len(s.Raw)}, args[2:], nil
+	}
+	return nil, nil, fmt.Errorf("unknown selector %q, want %s", args[0], selectorHelp)
+}
+
+// parseElems returns the elements matching the filters following
+// "elems" in args, and the remaining arguments.
+func parseElems(d *encore.Data, args []string) ([]*selection, []string, error) {
+	meas, staff, voice := -1, -1, -1
+	typ := ""
+	args = args[1:]
+filters:
+	for len(args) >= 2 {
+		var err error
+		switch args[0] {
+		case "meas", "measure":
+			meas, err = selectorIndex(args, len(d.Measures))
+		case "staff":
+			staff, err = selectorIndex(args, len(d.Staff))
+		case "voice":
+			voice, err = selectorIndex(args, 16)
+		case "type":
+			typ = args[1]
+		default:
+			break filters
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		args = args[2:]
+	}
+
+	var result []*selection
+	for i, m := range d.Measures {
+		if meas >= 0 && i != meas {
+			continue
+		}
+		for j, e := range m.Elems {
+			if staff >= 0 && e.GetStaff() != staff ||
+				voice >= 0 && e.Voice() != voice ||
+				typ != "" && (e.TypeSpecific == nil || !strings.EqualFold(e.GetTypeName(), typ)) {
+				continue
+			}
+			name := fmt.Sprintf("meas %d elem %d", i, j)
+			if e.TypeSpecific != nil {
+				name += " (" + e.GetTypeName() + ")"
+			}
+			result = append(result, &selection{name, e.Offset, len(e.Raw)})
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil, fmt.Errorf("no elements match")
+	}
+	return result, args, nil
+}
+
+// parseSelections is like parseSelector, but also accepts element
+// filters that select more than one element.
+func parseSelections(d *encore.Data, args []string) ([]*selection, []string, error) {
+	if len(args) > 0 && args[0] == "elems" {
+		return parseElems(d, args)
+	}
+	sel, rest, err := parseSelector(d, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []*selection{sel}, rest, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hanwen/go-enc2ly/abc"
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+type voiceStats struct {
+	Voice int
+	Notes int
+}
+
+type staffStats struct {
+	Index  int
+	Name   string
+	Notes  int
+	Voices []voiceStats
+
+	// Range of written pitches in scientific notation, eg. "C4".
+	Lowest, Highest string
+
+	low, high int
+}
+
+type timeChange struct {
+	Measure int
+	Time    string
+}
+
+type tempoChange struct {
+	Measure int
+	Bpm     int
+}
+
+type keyChange struct {
+	Measure int
+	Staff   int
+	Key     string
+}
+
+// scoreStats summarizes an Encore file.
+type scoreStats struct {
+	File string
+
+	Measures int
+	Staves   []*staffStats
+
+	Times  []timeChange
+	Tempos []tempoChange
+	Keys   []keyChange
+
+	Tuplets int
+	Ties    int
+
+	// Ignored counts the elements the converters skip, by type.
+	Ignored map[string]int
+}
+
+// pitchName returns the written pitch of n, in the given clef, in
+// scientific pitch notation.
+func pitchName(n *encore.Note, clef byte) string {
+	lp, _ := convertNote(n, basePitch(clef))
+	p := abcPitch(lp)
+	s := string("CDEFGAB"[p.Step])
+	if p.Alteration > 0 {
+		s += strings.Repeat("#", p.Alteration)
+	} else {
+		s += strings.Repeat("b", -p.Alteration)
+	}
+	return s + fmt.Sprint(p.Octave)
+}
+
+func keyName(key byte) string {
+	return (&abc.Key{Fifths: keyFifths(key)}).String()
+}
+
+func newScoreStats(name string, d *encore.Data) *scoreStats {
+	st := &scoreStats{
+		File:     name,
+		Measures: len(d.Measures),
+		Ignored:  map[string]int{},
+	}
+	for i, s := range d.Staff {
+		st.Staves = append(st.Staves, &staffStats{Index: i, Name: s.DisplayName(), low: 256, high: -1})
+	}
+	if len(d.Lines) > 0 {
+		for _, s := range d.Lines[0].Staffs {
+			st.Keys = append(st.Keys, keyChange{0, int(s.StaffIdx), keyName(s.Key)})
+		}
+	}
+
+	for i, m := range d.Measures {
+		if i == 0 || m.TimeSignature() != d.Measures[i-1].TimeSignature() {
+			st.Times = append(st.Times, timeChange{i, m.TimeSignature()})
+		}
+		if i == 0 || m.Bpm != d.Measures[i-1].Bpm {
+			st.Tempos = append(st.Tempos, tempoChange{i, int(m.Bpm)})
+		}
+		for _, e := range m.Elems {
+			if !walked(e) {
+				name := "?"
+				if e.TypeSpecific != nil {
+					name = e.GetTypeName()
+				}
+				st.Ignored[name]++
+			}
+			switch t := e.TypeSpecific.(type) {
+			case *encore.Tie:
+				st.Ties++
+			case *encore.Beam:
+				if t.TupletNumber != 0 {
+					st.Tuplets++
+				}
+			case *encore.KeyChange:
+				st.Keys = append(st.Keys, keyChange{i, e.GetStaff(), keyName(t.NewKey)})
+			}
+		}
+	}
+
+	// Notes are counted through walkVoice, which knows the clef
+	// for naming them. Elements of staves that are not in d are
+	// ignored.
+	staves, keys := voices(d)
+	for _, k := range keys {
+		if k.staff >= len(st.Staves) {
+			continue
+		}
+		walkVoice(staves[k], &statsVoice{st.Staves[k.staff]}, nil)
+	}
+	return st
+}
+
+// statsVoice adds the notes of a voice to its staffStats.
+type statsVoice struct {
+	staff *staffStats
+}
+
+func (v *statsVoice) startMeasure(e *encore.MeasElem, prev *encore.Measure) {}
+func (v *statsVoice) barCheck()                                             {}
+func (v *statsVoice) timeSignature(m *encore.Measure)                       {}
+func (v *statsVoice) key(e *encore.MeasElem, key byte)                      {}
+func (v *statsVoice) clef(e *encore.MeasElem, clef byte)                    {}
+func (v *statsVoice) skip(ticks int)                                        {}
+func (v *statsVoice) tupletStart(e *encore.MeasElem, b *encore.Beam)        {}
+func (v *statsVoice) tupletEnd()                                            {}
+func (v *statsVoice) tie(e *encore.MeasElem)                                {}
+func (v *statsVoice) rest(e *encore.MeasElem, r *encore.Rest)               {}
+
+func (v *statsVoice) note(e *encore.MeasElem, n *encore.Note, clef byte, chord bool) {
+	v.staff.addNote(e, n, clef)
+}
+
+func (s *staffStats) addNote(e *encore.MeasElem, n *encore.Note, clef byte) {
+	s.Notes++
+	v := e.Voice()
+	i := sort.Search(len(s.Voices), func(i int) bool { return s.Voices[i].Voice >= v })
+	if i == len(s.Voices) || s.Voices[i].Voice != v {
+		s.Voices = append(s.Voices, voiceStats{})
+		copy(s.Voices[i+1:], s.Voices[i:])
+		s.Voices[i] = voiceStats{Voice: v}
+	}
+	s.Voices[i].Notes++
+
+	p := int(n.SemitonePitch)
+	if p < s.low {
+		s.low = p
+		s.Lowest = pitchName(n, clef)
+	}
+	if p > s.high {
+		s.high = p
+		s.Highest = pitchName(n, clef)
+	}
+}
+
+func (st *scoreStats) write(w io.Writer) {
+	fmt.Fprintf(w, "%s:\n", st.File)
+	fmt.Fprintf(w, "  measures: %d\n", st.Measures)
+	fmt.Fprintf(w, "  staves: %d\n", len(st.Staves))
+	for _, s := range st.Staves {
+		var voices []string
+		for _, v := range s.Voices {
+			voices = append(voices, fmt.Sprintf("voice %d: %d", v.Voice, v.Notes))
+		}
+		fmt.Fprintf(w, "    %d %q: %d notes", s.Index, s.Name, s.Notes)
+		if s.Notes > 0 {
+			fmt.Fprintf(w, " (%s), range %s - %s", strings.Join(voices, ", "), s.Lowest, s.Highest)
+		}
+		fmt.Fprintln(w)
+	}
+	var times, tempos, keys []string
+	for _, t := range st.Times {
+		times = append(times, fmt.Sprintf("%s at %d", t.Time, t.Measure))
+	}
+	for _, t := range st.Tempos {
+		tempos = append(tempos, fmt.Sprintf("%d at %d", t.Bpm, t.Measure))
+	}
+	for _, k := range st.Keys {
+		keys = append(keys, fmt.Sprintf("%s at %d staff %d", k.Key, k.Measure, k.Staff))
+	}
+	fmt.Fprintf(w, "  time: %s\n", strings.Join(times, ", "))
+	fmt.Fprintf(w, "  tempo: %s\n", strings.Join(tempos, ", "))
+	fmt.Fprintf(w, "  keys: %s\n", strings.Join(keys, ", "))
+	fmt.Fprintf(w, "  tuplets: %d, ties: %d\n", st.Tuplets, st.Ties)
+
+	var ignored []string
+	for k, n := range st.Ignored {
+		ignored = append(ignored, fmt.Sprintf("%s %d", k, n))
+	}
+	sort.Strings(ignored)
+	if len(ignored) > 0 {
+		fmt.Fprintf(w, "  ignored: %s\n", strings.Join(ignored, ", "))
+	}
+}
+
+// stats prints a summary of Encore files, as text or as one JSON
+// object per file.
+func stats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON, one object per line")
+	fs.Parse(args)
+
+	enc := json.NewEncoder(os.Stdout)
+	failed := false
+	for _, name := range fs.Args() {
+		d, err := readEnc(name)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			failed = true
+			continue
+		}
+		st := newScoreStats(name, d)
+		if *asJSON {
+			err = enc.Encode(st)
+		} else {
+			st.write(os.Stdout)
+		}
+		if err != nil {
+			log.Fatalf("stats: %v", err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+func TestScoreStatsJSON(t *testing.T) {
+	d := testScore(t, 2, 2,
+		testElem{0, 0, 0, 0, testNote(2, 4, 67)},
+		testElem{0, 480, 0, 0, &encore.Beam{SubBeams: make([]encore.SubBeam, 1)}},
+		testElem{0, 480, 0, 0, testNote(3, 5, 69)},
+		testElem{0, 720, 0, 0, testNote(3, 4, 67)},
+		testElem{0, 720, 0, 0, &encore.Tie{}},
+		testElem{1, 0, 0, 0, &encore.KeyChange{NewKey: 9}},
+		testElem{1, 0, 0, 0, testNote(1, 4, 67)},
+		testElem{1, 0, 0, 1, testNote(1, 0, 60)},
+		testElem{0, 0, 1, 0, testNote(2, 0, 60)},
+		testElem{0, 480, 1, 0, &encore.Clef{ClefType: 1}},
+		testElem{0, 480, 1, 0, testNote(2, 2, 43)},
+		testElem{1, 0, 1, 0, testNote(1, 0, 40)},
+	)
+	d.Measures[1].Bpm = 90
+	d.Measures[1].TimeSigNum = 3
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(newScoreStats("test.enc", d)); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	// The second staff changes to the bass clef before its lowest
+	// notes; the beam has no tuplet, so it is ignored.
+	want := `{
+		"File": "test.enc",
+		"Measures": 2,
+		"Staves": [
+			{"Index": 0, "Name": "Staff 1", "Notes": 5,
+				"Voices": [{"Voice": 0, "Notes": 4}, {"Voice": 1, "Notes": 1}],
+				"Lowest": "C4", "Highest": "A4"},
+			{"Index": 1, "Name": "Staff 2", "Notes": 3,
+				"Voices": [{"Voice": 0, "Notes": 3}],
+				"Lowest": "E2", "Highest": "C4"}
+		],
+		"Times": [{"Measure": 0, "Time": "4/4"}, {"Measure": 1, "Time": "3/4"}],
+		"Tempos": [{"Measure": 0, "Bpm": 120}, {"Measure": 1, "Bpm": 90}],
+		"Keys": [
+			{"Measure": 0, "Staff": 0, "Key": "C"},
+			{"Measure": 0, "Staff": 1, "Key": "C"},
+			{"Measure": 1, "Staff": 0, "Key": "D"}
+		],
+		"Tuplets": 0,
+		"Ties": 1,
+		"Ignored": {"Beam": 1}
+	}`
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(want)); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != compact.String() {
+		t.Errorf("got\n%s\nwant\n%s", got, compact.String())
+	}
+}
+
+func TestScoreStatsStaffRange(t *testing.T) {
+	d := testScore(t, 1, 1,
+		testElem{0, 0, 0, 0, testNote(3, 4, 67)},
+		testElem{0, 240, 0, 0, testNote(3, 4, 67)},
+	)
+	// Data built by hand need not be linked like a file.
+	d.Measures[0].Elems[1].StaffIdx = 2
+	st := newScoreStats("test.enc", d)
+	if st.Staves[0].Notes != 1 {
+		t.Errorf("got %d notes, want 1", st.Staves[0].Notes)
+	}
+}
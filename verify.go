@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// verify checks that each file survives a parse/encode round trip
+// unchanged.
+func verify(args []string) {
+	failed := false
+	for _, name := range args {
+		content, err := ioutil.ReadFile(name)
+		if err == nil {
+			err = encore.Verify(content)
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			failed = true
+		} else {
+			fmt.Printf("%s: ok\n", name)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
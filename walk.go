@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hanwen/go-enc2ly/encore"
+)
+
+// voiceWriter receives the contents of a single voice from walkVoice,
+// in time order.
+type voiceWriter interface {
+	// startMeasure is called for the first element of a measure in
+	// the voice. prev is the measure of the element before it, or
+	// nil.
+	startMeasure(e *encore.MeasElem, prev *encore.Measure)
+
+	// barCheck is called when a note or rest starts a measure.
+	barCheck()
+
+	timeSignature(m *encore.Measure)
+	key(e *encore.MeasElem, key byte)
+	clef(e *encore.MeasElem, clef byte)
+
+	// skip is called for gaps between the notes and rests.
+	skip(ticks int)
+
+	tupletStart(e *encore.MeasElem, b *encore.Beam)
+	tupletEnd()
+
+	// tie is called for a tie that starts at the last note.
+	tie(e *encore.MeasElem)
+
+	// note is called for each note; clef is the clef in effect,
+	// and chord is set if it sounds together with the previous
+	// note.
+	note(e *encore.MeasElem, n *encore.Note, clef byte, chord bool)
+	rest(e *encore.MeasElem, r *encore.Rest)
+}
+
+// voices groups the elements of data by staff and voice. The keys are
+// sorted, and the elements of each voice are in elemSequence order.
+// Clef changes apply to the whole staff, so they are added to each
+// voice of their staff.
+func voices(data *encore.Data) (map[idKey][]*encore.MeasElem, []idKey) {
+	staves := map[idKey][]*encore.MeasElem{}
+	clefs := map[int][]*encore.MeasElem{}
+	for _, m := range data.Measures {
+		for _, e := range m.Elems {
+			if _, ok := e.TypeSpecific.(*encore.Clef); ok {
+				clefs[e.GetStaff()] = append(clefs[e.GetStaff()], e)
+				continue
+			}
+			key := idKey{
+				staff: e.GetStaff(),
+				voice: e.Voice(),
+			}
+			staves[key] = append(staves[key], e)
+		}
+	}
+	sortedKeys := idKeys{}
+	for k, elems := range staves {
+		sortedKeys = append(sortedKeys, k)
+		elems = append(elems, clefs[k.staff]...)
+		sort.Sort(elemSequence(elems))
+		staves[k] = elems
+	}
+	sort.Sort(sortedKeys)
+	return staves, sortedKeys
+}
+
+// walkVoice feeds the elements of a voice, sorted as elemSequence, to
+// w. Problems with the input are added to diag.
+func walkVoice(elems []*encore.MeasElem, w voiceWriter, diag *Diagnostics) {
+	lastTick := -1
+	var nextTick int
+	var endTupletTick int
+	inTuplet := false
+
+	// The clef is set from the line staff at the start of each
+	// line, and by clef changes.
+	var line *encore.LineStaffData
+	var clef byte
+	haveClef := false
+	setClef := func(e *encore.MeasElem, c byte) {
+		if !haveClef || c != clef {
+			clef, haveClef = c, true
+			w.clef(e, c)
+		}
+	}
+	for i, e := range elems {
+		p := e.Problem()
+		if n, ok := e.TypeSpecific.(*encore.Note); ok && p == "" {
+			c := clef
+			if l := e.LineStaffData; l != nil && l != line {
+				c = l.Clef
+			}
+			p = noteProblem(n, c)
+		}
+		if p != "" {
+			diag.Add(Error, e, "%s; skipped", p)
+			continue
+		}
+		if inTuplet && e.AbsTick() > endTupletTick {
+			w.tupletEnd()
+			inTuplet = false
+			endTupletTick = 0
+		}
+
+		if e.GetTick() == 0 && e.AbsTick() > lastTick && e.GetDurationTick() > 0 {
+			w.barCheck()
+		}
+
+		if i == 0 || e.Measure != elems[i-1].Measure && e.GetTick() == 0 {
+			var prev *encore.Measure
+			if i > 0 {
+				prev = elems[i-1].Measure
+			}
+			w.startMeasure(e, prev)
+		}
+		if i == 0 || (e.GetTick() == 0 && elems[i-1].Measure.TimeSignature() != e.Measure.TimeSignature()) {
+			w.timeSignature(e.Measure)
+		}
+		if i == 0 && e.LineStaffData != nil {
+			if k := e.LineStaffData.Key; k > encore.MaxKey {
+				diag.Add(Error, e, "key %d of the line out of range; ignored", k)
+			} else {
+				w.key(e, k)
+			}
+		}
+		if l := e.LineStaffData; l != nil && l != line {
+			line = l
+			setClef(e, l.Clef)
+		}
+
+		if nextTick < e.AbsTick() {
+			w.skip(e.AbsTick() - nextTick)
+			nextTick = e.AbsTick()
+		}
+
+		end := e.AbsTick() + e.GetDurationTick()
+		switch t := e.TypeSpecific.(type) {
+		case *encore.Beam:
+			if t.TupletNumber != 0 {
+				if inTuplet {
+					diag.Add(Error, e, "tuplet starts inside another tuplet; ending the first")
+					w.tupletEnd()
+				}
+
+				endTupletTick = e.Measure.AbsTick + int(t.EndNoteTick)
+				inTuplet = true
+				w.tupletStart(e, t)
+			}
+		case *encore.Tie:
+			w.tie(e)
+		case *encore.Note:
+			w.note(e, t, clef, e.AbsTick() == lastTick)
+			lastTick = e.AbsTick()
+			if end > nextTick {
+				nextTick = end
+			}
+		case *encore.Rest:
+			w.rest(e, t)
+			if end > nextTick {
+				nextTick = end
+			}
+		case *encore.KeyChange:
+			w.key(e, t.NewKey)
+		case *encore.Clef:
+			setClef(e, t.ClefType)
+		}
+	}
+	if inTuplet {
+		w.tupletEnd()
+	}
+}
+
+// noteProblem returns what makes n unprintable in clef, or "". The
+// notation has accidentals up to double sharps and flats.
+func noteProblem(n *encore.Note, clef byte) string {
+	p, _ := convertNote(n, basePitch(clef))
+	if p.Alteration < -2 || p.Alteration > 2 {
+		return fmt.Sprintf("alteration %d out of range", p.Alteration)
+	}
+	return ""
+}
+
+// walked returns whether walkVoice passes e on to the voiceWriter.
+// Beams without a tuplet are left to automatic beaming.
+func walked(e *encore.MeasElem) bool {
+	switch t := e.TypeSpecific.(type) {
+	case *encore.Note, *encore.Rest, *encore.Tie, *encore.KeyChange, *encore.Clef:
+		return true
+	case *encore.Beam:
+		return t.TupletNumber != 0
+	}
+	return false
+}
+
+// tupletRatio returns the number of notes in a tuplet started by b
+// and the number of normal notes they take the time of, eg. 3 and 2
+// for a triplet. The ratio comes from the note w if it has one.
+func tupletRatio(b *encore.Beam, w *encore.WithDuration) (actual, normal int) {
+	actual, normal = w.TupletDen(), w.TupletNum()
+	if actual == 0 || normal == 0 {
+		actual = int(b.TupletNumber)
+		for normal = 1; 2*normal < actual; normal *= 2 {
+		}
+	}
+	return actual, normal
+}
+
+// keyAlteration returns the alteration of step, 0 for C, in the major
+// key with the given number of sharps (positive) or flats (negative).
+func keyAlteration(fifths, step int) int {
+	sharps := []int{3, 0, 4, 1, 5, 2, 6}
+	for i := 0; i < fifths && i < 7; i++ {
+		if sharps[i] == step {
+			return 1
+		}
+	}
+	for i := 0; i < -fifths && i < 7; i++ {
+		if sharps[6-i] == step {
+			return -1
+		}
+	}
+	return 0
+}
+
+// accidentals has the alterations of the accidentals written in a
+// measure, by step and octave.
+type accidentals map[[2]int]int
+
+// add returns whether a note with the given step, octave and
+// alteration needs an accidental in the key with the given fifths,
+// and records it.
+func (a accidentals) add(fifths, step, octave, alteration int) bool {
+	k := [2]int{step, octave}
+	cur, ok := a[k]
+	if !ok {
+		cur = keyAlteration(fifths, step)
+	}
+	if cur == alteration {
+		return false
+	}
+	a[k] = alteration
+	return true
+}